@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// comment_anchor ties the comments of a requirements statement to the
+// generated statement it describes.
+// the generated statement is located by a name (macro name, target
+// name, ...) appearing in the rendered script.
+type comment_anchor struct {
+	key      string
+	pos      Pos
+	comments []string
+}
+
+// stmt_key returns the name under which a statement shows up in a
+// generated script, or "" if there is none.
+func stmt_key(stmt Stmt) string {
+	switch x := stmt.(type) {
+	case *Package:
+		return x.Name
+	case *Author:
+		return x.Name
+	case *Manager:
+		return x.Name
+	case *Version:
+		return x.Value
+	case *UsePkg:
+		return x.Package
+	case *Library:
+		return x.Name
+	case *Application:
+		return x.Name
+	case *Alias:
+		return x.Name
+	case *Macro:
+		return x.Name
+	case *MacroAppend:
+		return x.Name
+	case *MacroPrepend:
+		return x.Name
+	case *MacroRemove:
+		return x.Name
+	case *Path:
+		return x.Name
+	case *PathAppend:
+		return x.Name
+	case *PathPrepend:
+		return x.Name
+	case *PathRemove:
+		return x.Name
+	case *SetEnv:
+		return x.Name
+	case *SetAppend:
+		return x.Name
+	case *SetRemove:
+		return x.Name
+	case *SetPrepend:
+		return x.Name
+	case *SetRemoveRegexp:
+		return x.Name
+	case *MacroRemoveAll:
+		return x.Name
+	case *MacroRemoveRegexp:
+		return x.Name
+	case *MacroRemoveAllRegexp:
+		return x.Name
+	case *PathRemoveRegexp:
+		return x.Name
+	case *Pattern:
+		return x.Name
+	case *ApplyPattern:
+		return x.Name
+	case *Tag:
+		return x.Name
+	case *ApplyTag:
+		return x.Name
+	case *TagExclude:
+		return x.Name
+	case *MakeFragment:
+		return x.Name
+	case *Document:
+		return x.Name
+	case *ProjectName:
+		return x.Name
+	case *ProjectUse:
+		return x.Name
+	case *Container:
+		return x.Name
+	}
+	return ""
+}
+
+// insert_comments inserts the comments of each anchor right before the
+// line of the rendered script declaring the anchor's key.
+// a line declares a key if the key is the first string of the line (as in
+// name: "Foo" or hwaf_declare_macro("Foo", ...)) or a mapping key (as in
+// Foo: ...). lines merely mentioning the key, e.g. in a value, are only
+// used when no line declares it.
+// anchors are processed in order, each search resuming from the line of
+// the previous match. a line gets the comments of a single anchor, so
+// statements sharing a name get the comments of their own statement,
+// unless the script holds fewer lines for that name.
+// comments which could not be placed are appended at the end of the
+// script, together with their original location.
+func insert_comments(data []byte, anchors []comment_anchor) []byte {
+	if len(anchors) == 0 {
+		return data
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+
+	// do not consider the file header
+	beg := 0
+	for beg < len(lines) && strings.HasPrefix(lines[beg], "##") {
+		beg++
+	}
+
+	inserts := make(map[int][]string)
+	orphans := []comment_anchor{}
+	cur := beg
+	for _, anchor := range anchors {
+		idx := -1
+		if anchor.key != "" {
+			idx = find_anchor(lines, anchor.key, beg, cur, inserts)
+		}
+		if idx < 0 {
+			orphans = append(orphans, anchor)
+			continue
+		}
+		line := lines[idx]
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		for _, comment := range anchor.comments {
+			inserts[idx] = append(inserts[idx], indent+comment+"\n")
+		}
+		cur = idx
+	}
+
+	out := new(bytes.Buffer)
+	for i, line := range lines {
+		for _, comment := range inserts[i] {
+			out.WriteString(comment)
+		}
+		out.WriteString(line)
+	}
+
+	if len(orphans) > 0 {
+		if out.Len() > 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+			out.WriteString("\n")
+		}
+		out.WriteString("\n## comments from the requirements file\n")
+		for _, anchor := range orphans {
+			fmt.Fprintf(out, "## %v\n", anchor.pos)
+			for _, comment := range anchor.comments {
+				fmt.Fprintf(out, "%s\n", comment)
+			}
+		}
+	}
+	return out.Bytes()
+}
+
+// find_anchor returns the index of the line where the comments of key
+// go, or -1.
+// lines declaring key are preferred over lines mentioning it, and lines
+// with no comments yet over the others. the search starts at cur, then
+// wraps to beg.
+func find_anchor(lines []string, key string, beg, cur int, inserts map[int][]string) int {
+	re := regexp.MustCompile(`(^|[^\w.-])` + regexp.QuoteMeta(key) + `($|[^\w.-])`)
+	mentions := re.MatchString
+	declares := func(line string) bool {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, key+":") || strings.HasPrefix(line, strconv.Quote(key)+":") {
+			return true
+		}
+		i := strings.Index(line, `"`)
+		if i < 0 {
+			return false
+		}
+		str, err := strconv.QuotedPrefix(line[i:])
+		if err != nil {
+			return false
+		}
+		str, err = strconv.Unquote(str)
+		return err == nil && (str == key || strings.HasSuffix(str, "/"+key))
+	}
+
+	for _, match := range []func(string) bool{declares, mentions} {
+		for _, free := range []bool{true, false} {
+			for _, start := range []int{cur, beg} {
+				for i := start; i < len(lines); i++ {
+					if free && len(inserts[i]) > 0 {
+						continue
+					}
+					if match(lines[i]) {
+						return i
+					}
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// EOF
@@ -58,30 +58,30 @@ func use_list(wscript *hlib.Wscript_t) []string {
 	return uses
 }
 
-func cmt_arg_map(args []string) map[string]string {
+func cmt_arg_map(args []string) (map[string]string, error) {
 	o := make(map[string]string, len(args))
 	for _, v := range args {
 		idx := strings.Index(v, "=")
 		if idx < 0 {
-			panic(fmt.Errorf("cmt2yml: could not find '=' in string [%s]", v))
+			return o, fmt.Errorf("cmt2yml: could not find '=' in string [%s]", v)
 		}
 		if idx < 1 {
-			panic(fmt.Errorf("cmt2yml: malformed string [%s] (idx<0)", v))
+			return o, fmt.Errorf("cmt2yml: malformed string [%s] (idx<0)", v)
 		}
 		kk := v[:idx]
 		vv := v[idx+1:]
 		if vv == "" {
-			panic(fmt.Errorf("cmt2yml: malformed string [%s] (empty string)", v))
+			return o, fmt.Errorf("cmt2yml: malformed string [%s] (empty string)", v)
 		}
-		if vv[0] == '"' {
-			vv = vv[1:]
+		if len(vv) > 1 && is_quote(vv[0]) && vv[len(vv)-1] == vv[0] {
+			vv = vv[1 : len(vv)-1]
 		}
 		if strings.HasPrefix(vv, "../") {
 			vv = vv[len("../"):]
 		}
 		o[kk] = vv
 	}
-	return o
+	return o, nil
 }
 
 func cnv_atlas_library(wscript *hlib.Wscript_t, stmt Stmt) error {
@@ -93,7 +93,10 @@ func cnv_atlas_library(wscript *hlib.Wscript_t, stmt Stmt) error {
 		libname = filepath.Base(wscript.Package.Name)
 	default:
 		// named_installed_library pattern
-		margs := cmt_arg_map(x.Args)
+		margs, err := cmt_arg_map(x.Args)
+		if err != nil {
+			return err
+		}
 		libname = margs["library"]
 	}
 	if libname == "" {
@@ -130,7 +133,10 @@ func cnv_atlas_component_library(wscript *hlib.Wscript_t, stmt Stmt) error {
 		libname = filepath.Base(wscript.Package.Name)
 	default:
 		// named_component_library pattern
-		margs := cmt_arg_map(x.Args)
+		margs, err := cmt_arg_map(x.Args)
+		if err != nil {
+			return err
+		}
 		libname = margs["library"]
 	}
 	if libname == "" {
@@ -167,7 +173,10 @@ func cnv_atlas_dual_use_library(wscript *hlib.Wscript_t, stmt Stmt) error {
 		libname = filepath.Base(wscript.Package.Name)
 	default:
 		// named_dual_use_library pattern
-		margs := cmt_arg_map(x.Args)
+		margs, err := cmt_arg_map(x.Args)
+		if err != nil {
+			return err
+		}
 		if _, ok := margs["library"]; ok {
 			libname = margs["library"]
 		} else {
@@ -209,7 +218,10 @@ func cnv_atlas_tpcnv_library(wscript *hlib.Wscript_t, stmt Stmt) error {
 		libname = filepath.Base(wscript.Package.Name)
 	default:
 		// named_tpcnv_library pattern
-		margs := cmt_arg_map(x.Args)
+		margs, err := cmt_arg_map(x.Args)
+		if err != nil {
+			return err
+		}
 		libname = margs["name"]
 	}
 	if libname == "" {
@@ -309,13 +321,16 @@ func cnv_atlas_install_data(wscript *hlib.Wscript_t, stmt Stmt) error {
 
 func cnv_atlas_install_java(wscript *hlib.Wscript_t, stmt Stmt) error {
 	x := stmt.(*ApplyPattern)
-	fmt.Printf(">>> [%s] \n", x.Name)
+	logf(">>> [%s] \n", x.Name)
 	return nil
 }
 
 func cnv_atlas_dictionary(wscript *hlib.Wscript_t, stmt Stmt) error {
 	x := stmt.(*ApplyPattern)
-	margs := cmt_arg_map(x.Args)
+	margs, err := cmt_arg_map(x.Args)
+	if err != nil {
+		return err
+	}
 	pkgname := filepath.Base(wscript.Package.Name)
 	libname := margs["dict"] + "Dict"
 	selfile := pkgname + "/" + margs["selectionfile"]
@@ -347,7 +362,10 @@ func cnv_atlas_dictionary(wscript *hlib.Wscript_t, stmt Stmt) error {
 
 func cnv_atlas_unittest(wscript *hlib.Wscript_t, stmt Stmt) error {
 	x := stmt.(*ApplyPattern)
-	margs := cmt_arg_map(x.Args)
+	margs, err := cmt_arg_map(x.Args)
+	if err != nil {
+		return err
+	}
 	pkgname := filepath.Base(wscript.Package.Name)
 	name := margs["unit_test"]
 	tgtname := fmt.Sprintf("%s-test-%s", pkgname, name)
@@ -383,7 +401,10 @@ func cnv_atlas_unittest(wscript *hlib.Wscript_t, stmt Stmt) error {
 
 func cnv_atlas_athenarun_test(wscript *hlib.Wscript_t, stmt Stmt) error {
 	x := stmt.(*ApplyPattern)
-	margs := cmt_arg_map(x.Args)
+	margs, err := cmt_arg_map(x.Args)
+	if err != nil {
+		return err
+	}
 	pkgname := filepath.Base(wscript.Package.Name)
 	name := margs["name"]
 	tgtname := fmt.Sprintf("%s-runtest-%s", pkgname, name)
@@ -420,7 +441,10 @@ func cnv_atlas_athenarun_test(wscript *hlib.Wscript_t, stmt Stmt) error {
 
 func cnv_atlas_generic_install(wscript *hlib.Wscript_t, stmt Stmt) error {
 	x := stmt.(*ApplyPattern)
-	margs := cmt_arg_map(x.Args)
+	margs, err := cmt_arg_map(x.Args)
+	if err != nil {
+		return err
+	}
 	name := margs["name"]
 	source := margs["files"]
 	kind := margs["kind"]
@@ -449,7 +473,10 @@ func cnv_atlas_generic_install(wscript *hlib.Wscript_t, stmt Stmt) error {
 
 func cnv_atlas_install_trfs(wscript *hlib.Wscript_t, stmt Stmt) error {
 	x := stmt.(*ApplyPattern)
-	margs := cmt_arg_map(x.Args)
+	margs, err := cmt_arg_map(x.Args)
+	if err != nil {
+		return err
+	}
 	jo := margs["jo"]
 	tfs := margs["tfs"]
 	pkgname := filepath.Base(wscript.Package.Name)
@@ -10,7 +10,10 @@ import (
 
 func cnv_detcommon_shared_library(wscript *hlib.Wscript_t, stmt Stmt) error {
 	x := stmt.(*ApplyPattern)
-	margs := cmt_arg_map(x.Args)
+	margs, err := cmt_arg_map(x.Args)
+	if err != nil {
+		return err
+	}
 	libname := ""
 	if _, ok := margs["library"]; ok {
 		libname = margs["library"]
@@ -57,7 +60,10 @@ func cnv_detcommon_install_headers(wscript *hlib.Wscript_t, stmt Stmt) error {
 
 func cnv_trigconf_application(wscript *hlib.Wscript_t, stmt Stmt) error {
 	x := stmt.(*ApplyPattern)
-	margs := cmt_arg_map(x.Args)
+	margs, err := cmt_arg_map(x.Args)
+	if err != nil {
+		return err
+	}
 	appname := margs["name"]
 	if appname == "" {
 		return fmt.Errorf(
@@ -91,7 +97,10 @@ func cnv_trigconf_application(wscript *hlib.Wscript_t, stmt Stmt) error {
 
 func cnv_detcommon_generic_install(wscript *hlib.Wscript_t, stmt Stmt) error {
 	x := stmt.(*ApplyPattern)
-	margs := cmt_arg_map(x.Args)
+	margs, err := cmt_arg_map(x.Args)
+	if err != nil {
+		return err
+	}
 	name := margs["name"]
 	source := margs["files"]
 	kind := margs["kind"]
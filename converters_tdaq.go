@@ -10,7 +10,10 @@ import (
 
 func cnv_tdaq_library(wscript *hlib.Wscript_t, stmt Stmt) error {
 	x := stmt.(*ApplyPattern)
-	margs := cmt_arg_map(x.Args)
+	margs, err := cmt_arg_map(x.Args)
+	if err != nil {
+		return err
+	}
 	libname := ""
 	if _, ok := margs["library"]; ok {
 		libname = margs["library"]
@@ -49,7 +52,10 @@ func cnv_tdaq_library(wscript *hlib.Wscript_t, stmt Stmt) error {
 
 func cnv_tdaq_application(wscript *hlib.Wscript_t, stmt Stmt) error {
 	x := stmt.(*ApplyPattern)
-	margs := cmt_arg_map(x.Args)
+	margs, err := cmt_arg_map(x.Args)
+	if err != nil {
+		return err
+	}
 	appname := margs["name"]
 	if appname == "" {
 		return fmt.Errorf(
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Diag is a problem found while parsing or converting a requirements file
+type Diag struct {
+	Pos  Pos
+	Msg  string
+	Warn bool // warnings do not make a conversion partial
+}
+
+func (d Diag) Error() string {
+	return fmt.Sprintf("%v: %s", d.Pos, d.Msg)
+}
+
+// diag records a problem located at stmt
+func (req *ReqFile) diag(stmt Stmt, err error) {
+	req.Diags = append(req.Diags, Diag{Pos: req.Pos(stmt), Msg: err.Error()})
+}
+
+// warn records a warning located at stmt
+func (req *ReqFile) warn(stmt Stmt, err error) {
+	req.Diags = append(req.Diags, Diag{Pos: req.Pos(stmt), Msg: err.Error(), Warn: true})
+}
+
+// Errors returns the diagnostics which are not mere warnings
+func (req *ReqFile) Errors() []Diag {
+	errs := make([]Diag, 0, len(req.Diags))
+	for _, diag := range req.Diags {
+		if !diag.Warn {
+			errs = append(errs, diag)
+		}
+	}
+	return errs
+}
+
+// IsPartial returns whether some statements could not be converted
+func (req *ReqFile) IsPartial() bool {
+	return len(req.Errors()) > 0
+}
+
+// EOF
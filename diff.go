@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// number of unchanged lines shown around each change of a unified diff
+const g_diff_context = 3
+
+// diff_op is a line of an edit script: kept (' '), removed ('-') or
+// added ('+')
+type diff_op struct {
+	kind byte
+	line string
+}
+
+// unified_diff returns the unified diff turning a into b, or nil if they
+// are the same
+func unified_diff(aname, bname string, a, b []byte) []byte {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	ops := diff_lines(split_lines(a), split_lines(b))
+
+	o := new(bytes.Buffer)
+	fmt.Fprintf(o, "--- %s\n+++ %s\n", aname, bname)
+	for beg := 0; beg < len(ops); {
+		// find the next change
+		for beg < len(ops) && ops[beg].kind == ' ' {
+			beg++
+		}
+		if beg == len(ops) {
+			break
+		}
+		// extend the hunk up to the first run of unchanged lines too long
+		// to be shared by two hunks
+		end := beg
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			n := 0
+			for end+n < len(ops) && ops[end+n].kind == ' ' {
+				n++
+			}
+			if end+n == len(ops) || n > 2*g_diff_context {
+				break
+			}
+			end += n
+		}
+		lo := beg - g_diff_context
+		if lo < 0 {
+			lo = 0
+		}
+		hi := end + g_diff_context
+		if hi > len(ops) {
+			hi = len(ops)
+		}
+		write_hunk(o, ops, lo, hi)
+		beg = hi
+	}
+	return o.Bytes()
+}
+
+// write_hunk writes the hunk made of ops[lo:hi]
+func write_hunk(o *bytes.Buffer, ops []diff_op, lo, hi int) {
+	// line numbers of the start of the hunk, in a and in b
+	aline, bline := 1, 1
+	for _, op := range ops[:lo] {
+		if op.kind != '+' {
+			aline++
+		}
+		if op.kind != '-' {
+			bline++
+		}
+	}
+	alen, blen := 0, 0
+	for _, op := range ops[lo:hi] {
+		if op.kind != '+' {
+			alen++
+		}
+		if op.kind != '-' {
+			blen++
+		}
+	}
+	// empty ranges start at the line before them
+	if alen == 0 {
+		aline--
+	}
+	if blen == 0 {
+		bline--
+	}
+	fmt.Fprintf(o, "@@ -%s +%s @@\n", hunk_range(aline, alen), hunk_range(bline, blen))
+	for _, op := range ops[lo:hi] {
+		o.WriteByte(op.kind)
+		o.WriteString(op.line)
+		o.WriteString("\n")
+	}
+}
+
+func hunk_range(beg, n int) string {
+	if n == 1 {
+		return fmt.Sprintf("%d", beg)
+	}
+	return fmt.Sprintf("%d,%d", beg, n)
+}
+
+// split_lines splits data into lines, without their end of line
+func split_lines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// diff_lines returns the shortest edit script turning a into b, from
+// their longest common subsequence
+func diff_lines(a, b []string) []diff_op {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]diff_op, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diff_op{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diff_op{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diff_op{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diff_op{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diff_op{'+', b[j]})
+	}
+	return ops
+}
+
+// EOF
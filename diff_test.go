@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, table := range []struct {
+		a, b     string
+		expected string
+	}{
+		{
+			a:        "a\nb\nc\n",
+			b:        "a\nb\nc\n",
+			expected: "",
+		},
+		{
+			a:        "",
+			b:        "a\nb\n",
+			expected: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			a:        "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:        "1\n2\n3\n4\nfive\n6\n7\n8\n",
+			expected: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			// changes far apart go to different hunks
+			a:        "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:        "one\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			expected: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,3 @@\n 7\n 8\n 9\n-10\n",
+		},
+		{
+			// changes close to each other share a hunk
+			a:        "1\n2\n3\n4\n5\n6\n7\n",
+			b:        "one\n2\n3\n4\n5\n6\nseven\n",
+			expected: "--- a\n+++ b\n@@ -1,7 +1,7 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n-7\n+seven\n",
+		},
+	} {
+		diff := string(unified_diff("a", "b", []byte(table.a), []byte(table.b)))
+		if diff != table.expected {
+			t.Fatalf("%q -> %q: expected:\n%s\ngot:\n%s", table.a, table.b, table.expected, diff)
+		}
+	}
+}
+
+func TestDiffMode(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "cmt2yml-")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(tmp)
+
+	buf := new(bytes.Buffer)
+	g_diff = new_diff(buf)
+	defer func() { g_diff = nil }()
+
+	same := filepath.Join(tmp, "same.yml")
+	changed := filepath.Join(tmp, "changed.yml")
+	for _, fname := range []string{same, changed} {
+		err = ioutil.WriteFile(fname, []byte("a\nb\n"), 0644)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	for _, s := range []struct {
+		pkg, fname, data string
+	}{
+		{"Same", same, "a\nb\n"},
+		{"Changed", changed, "a\nc\n"},
+		{"New", filepath.Join(tmp, "new.yml"), "a\n"},
+	} {
+		err = write_script(s.pkg, s.fname, "", []byte(s.data))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	err = skip_script("User", filepath.Join(tmp, "user.yml"), "user-written file")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if path_exists(filepath.Join(tmp, "new.yml")) {
+		t.Fatalf("diff mode wrote a script")
+	}
+	data, err := ioutil.ReadFile(changed)
+	if err != nil || string(data) != "a\nb\n" {
+		t.Fatalf("diff mode modified a script (%v)", err)
+	}
+
+	expected := "--- " + changed + "\n+++ " + changed + "\n@@ -1,2 +1,2 @@\n a\n-b\n+c\n" +
+		"--- /dev/null\n+++ " + filepath.Join(tmp, "new.yml") + "\n@@ -0,0 +1 @@\n+a\n"
+	if buf.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	err = g_diff.summary(buf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected = "changed: Changed\nnew:     New\n>>> diff: 1 changed, 1 unchanged, 1 new, 1 skipped (user-written)\n"
+	if buf.String() != expected {
+		t.Fatalf("expected summary:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+// EOF
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hwaf/hwaf/hlib"
+)
+
+// Evaluator computes the values of the macros of a requirements file for
+// a given set of active tags, as 'cmt show macro_value' does.
+// It also keeps track of the tag alternatives selected across evaluations,
+// to find the ones which are never used.
+type Evaluator struct {
+	Tags     map[string]bool // active tags
+	Config   string          // tag the active tags derive from, e.g. x86_64-slc6-gcc47-opt
+	raw      map[string]string
+	names    []string
+	selected map[Stmt]map[int]bool
+	configs  []string
+}
+
+func NewEvaluator() *Evaluator {
+	return &Evaluator{
+		selected: make(map[Stmt]map[int]bool),
+	}
+}
+
+// Eval evaluates the statements of req (as returned by expand_stmts) with
+// the tags derived from config.
+// The active tags are config, its '-'-separated components, and the
+// tags they imply through tag and apply_tag statements.
+func (ev *Evaluator) Eval(req *ReqFile, stmts []Stmt, config string) {
+	ev.Config = config
+	ev.Tags = eval_tags(stmts, config)
+	ev.raw = make(map[string]string)
+	ev.names = ev.names[:0]
+	ev.configs = append(ev.configs, config)
+
+	for _, stmt := range stmts {
+		v := stmt_value(stmt)
+		if v == nil {
+			continue
+		}
+		val, ok := ev.selectValue(stmt, v)
+		if !ok {
+			continue
+		}
+		switch stmt.(type) {
+		case *Macro:
+			ev.set(v.Name, val)
+		case *MacroAppend:
+			ev.set(v.Name, join_words(ev.raw[v.Name], val))
+		case *MacroPrepend:
+			ev.set(v.Name, join_words(val, ev.raw[v.Name]))
+		case *MacroRemove:
+			ev.set(v.Name, strings.Replace(ev.raw[v.Name], val, "", 1))
+		case *MacroRemoveAll:
+			ev.set(v.Name, strings.Replace(ev.raw[v.Name], val, "", -1))
+		case *MacroRemoveRegexp:
+			if re, err := regexp.Compile(val); err == nil {
+				cur := ev.raw[v.Name]
+				if loc := re.FindStringIndex(cur); loc != nil {
+					ev.set(v.Name, cur[:loc[0]]+cur[loc[1]:])
+				}
+			}
+		case *MacroRemoveAllRegexp:
+			if re, err := regexp.Compile(val); err == nil {
+				ev.set(v.Name, re.ReplaceAllString(ev.raw[v.Name], ""))
+			}
+		}
+	}
+
+	for k, v := range map[string]string{
+		"tag":     config,
+		"package": req.Package.Name,
+	} {
+		if _, dup := ev.raw[k]; !dup {
+			ev.raw[k] = v
+		}
+	}
+}
+
+// selectValue returns the alternative of v selected by the active tags:
+// the first one whose tag expression matches, or the default one.
+func (ev *Evaluator) selectValue(stmt Stmt, v *hlib.Value) (string, bool) {
+	idx := -1
+	for i, kv := range v.Set {
+		if kv.Tag == "default" {
+			continue
+		}
+		expr, err := ParseTagExpr(kv.Tag)
+		if err != nil {
+			continue
+		}
+		if expr.Match(ev.Tags) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		for i, kv := range v.Set {
+			if kv.Tag == "default" {
+				idx = i
+				break
+			}
+		}
+	}
+	if idx < 0 {
+		return "", false
+	}
+	if ev.selected[stmt] == nil {
+		ev.selected[stmt] = make(map[int]bool)
+	}
+	ev.selected[stmt][idx] = true
+	return strings.Join(v.Set[idx].Value, " "), true
+}
+
+func (ev *Evaluator) set(name, value string) {
+	if _, ok := ev.raw[name]; !ok {
+		ev.names = append(ev.names, name)
+	}
+	ev.raw[name] = value
+}
+
+// join_words concatenates two values with a blank.
+// the parser trims the values, so the blanks CMT users put in front of
+// appended values are lost: they are assumed to be there.
+func join_words(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + " " + b
+}
+
+// Macros returns the names of the evaluated macros, in definition order
+func (ev *Evaluator) Macros() []string {
+	return append([]string(nil), ev.names...)
+}
+
+// Value returns the fully expanded value of a macro.
+// references to unknown or recursive macros are kept as-is.
+func (ev *Evaluator) Value(name string) (string, bool) {
+	return ev.expand(name, make(map[string]bool))
+}
+
+func (ev *Evaluator) expand(name string, seen map[string]bool) (string, bool) {
+	raw, ok := ev.raw[name]
+	if !ok || seen[name] {
+		return "", false
+	}
+	seen[name] = true
+	defer delete(seen, name)
+	return ParseValue(raw).Expand(func(ref string) (string, bool) {
+		return ev.expand(ref, seen)
+	}), true
+}
+
+// DeadBranches reports the tag alternatives of the statements of req which
+// were not selected by any of the evaluations so far
+func (ev *Evaluator) DeadBranches(req *ReqFile, stmts []Stmt) {
+	for _, stmt := range stmts {
+		v := stmt_value(stmt)
+		if v == nil || len(v.Set) < 2 {
+			continue
+		}
+		for i, kv := range v.Set {
+			if ev.selected[stmt][i] {
+				continue
+			}
+			req.warn(stmt, fmt.Errorf(
+				"%s: tag alternative [%s] is never selected (tags: %s)",
+				v.Name, kv.Tag, strings.Join(ev.configs, ", "),
+			))
+		}
+	}
+}
+
+// eval_tags returns the set of tags activated by config
+func eval_tags(stmts []Stmt, config string) map[string]bool {
+	tags := map[string]bool{config: true}
+	for _, part := range strings.Split(config, "-") {
+		tags[part] = true
+	}
+
+	// tag implications, up to a fixed point
+	for changed := true; changed; {
+		changed = false
+		activate := func(name string) {
+			if !tags[name] {
+				tags[name] = true
+				changed = true
+			}
+		}
+		for _, stmt := range stmts {
+			switch x := stmt.(type) {
+			case *Tag:
+				if !tags[x.Name] {
+					continue
+				}
+				for _, name := range x.Content {
+					activate(name)
+				}
+			case *ApplyTag:
+				activate(x.Name)
+			}
+		}
+	}
+
+	for _, stmt := range stmts {
+		if x, ok := stmt.(*TagExclude); ok && tags[x.Name] {
+			for _, name := range x.Content {
+				delete(tags, name)
+			}
+		}
+	}
+	return tags
+}
+
+// eval_macros writes the values of the macros of req for each of the
+// configs, and records the never selected tag alternatives as warnings
+func eval_macros(w io.Writer, req *ReqFile, configs []string) {
+	stmts := expand_stmts(req)
+	ev := NewEvaluator()
+	for _, config := range configs {
+		ev.Eval(req, stmts, config)
+		tags := make([]string, 0, len(ev.Tags))
+		for tag := range ev.Tags {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		fmt.Fprintf(w, ">>> macros of [%s] for tag [%s] (active tags: %s)\n",
+			req.Filename, config, strings.Join(tags, " "),
+		)
+		for _, name := range ev.Macros() {
+			val, _ := ev.Value(name)
+			fmt.Fprintf(w, "  %s=%q\n", name, val)
+		}
+	}
+	ev.DeadBranches(req, stmts)
+}
+
+// expand_stmts returns the statements of req with their patterns expanded,
+// as the renderer sees them.
+// the problems of the expansion are not recorded again: the renderer
+// already reported them.
+func expand_stmts(req *ReqFile) []Stmt {
+	scratch := *req
+	scratch.Diags = nil
+	scratch.Infos = make(map[Stmt]*StmtInfo, len(req.Infos))
+	for k, v := range req.Infos {
+		scratch.Infos[k] = v
+	}
+	r, err := NewRenderer(&scratch)
+	if err != nil {
+		return req.Stmts
+	}
+	stmts := r.expand_patterns(scratch.Stmts, 0)
+
+	// locate the expanded statements, for the diagnostics of the evaluation
+	for k, v := range scratch.Infos {
+		if _, ok := req.Infos[k]; !ok {
+			req.Infos[k] = v
+		}
+	}
+	return stmts
+}
+
+// EOF
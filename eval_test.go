@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestEvalTags(t *testing.T) {
+	req, err := parse_file("testdata/eval.txt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	tags := []string{}
+	for tag := range eval_tags(req.Stmts, "x86_64-slc6-gcc47-opt") {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	expected := []string{
+		"gcc4", "gcc47", "opt", "slc6",
+		"target-gcc47", "use_foo", "x86_64", "x86_64-slc6-gcc47-opt",
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("expected tags %v. got %v", expected, tags)
+	}
+}
+
+func TestEvaluator(t *testing.T) {
+	req, err := parse_file("testdata/eval.txt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for _, table := range []struct {
+		config   string
+		expected map[string]string
+	}{
+		{
+			config: "x86_64-slc6-gcc47-opt",
+			expected: map[string]string{
+				"cppflags":  "-DFOO=x86_64-slc6-gcc47-opt -O2 -Wall",
+				"foo_flags": "-DFOO=x86_64-slc6-gcc47-opt",
+				"lib":       " libB ",
+				"dirs":      "/a/x22 /b/y3",
+				"loop":      "${loop}-x",
+			},
+		},
+		{
+			config: "i686-slc6-icc11-dbg",
+			expected: map[string]string{
+				"cppflags": "-DFOO=i686-slc6-icc11-dbg -O1 -m32",
+			},
+		},
+	} {
+		ev := NewEvaluator()
+		ev.Eval(req, expand_stmts(req), table.config)
+		for name, expected := range table.expected {
+			val, ok := ev.Value(name)
+			if !ok {
+				t.Fatalf("%s: no macro [%s]", table.config, name)
+			}
+			if val != expected {
+				t.Fatalf("%s: expected %s=%q. got %q", table.config, name, expected, val)
+			}
+		}
+	}
+}
+
+func TestEvalDeadBranches(t *testing.T) {
+	req, err := parse_file("testdata/eval.txt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	eval_macros(ioutil.Discard, req, []string{"x86_64-slc6-gcc47-opt", "i686-slc6-gcc47-dbg"})
+
+	msgs := []string{}
+	for _, diag := range req.Diags {
+		if !diag.Warn {
+			t.Fatalf("unexpected error: %v", diag)
+		}
+		msgs = append(msgs, diag.Error())
+	}
+	expected := []string{
+		"testdata/eval.txt:7: cppflags: tag alternative [default] is never selected (tags: x86_64-slc6-gcc47-opt, i686-slc6-gcc47-dbg)",
+		"testdata/eval.txt:7: cppflags: tag alternative [icc] is never selected (tags: x86_64-slc6-gcc47-opt, i686-slc6-gcc47-dbg)",
+		"testdata/eval.txt:14: foo_flags: tag alternative [default] is never selected (tags: x86_64-slc6-gcc47-opt, i686-slc6-gcc47-dbg)",
+	}
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Fatalf("expected:\n%q\ngot:\n%q", expected, msgs)
+	}
+}
+
+func TestEvalPatterns(t *testing.T) {
+	req, err := parse_file("testdata/eval_pattern.txt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, table := range []struct {
+		config   string
+		expected string
+	}{
+		{"x86_64-slc6-gcc47-opt", "-DBAR64 -O2"},
+		{"i686-slc6-gcc47-opt", "-DBAR -O2"},
+	} {
+		ev := NewEvaluator()
+		ev.Eval(req, expand_stmts(req), table.config)
+		val, ok := ev.Value("Foo_flags")
+		if !ok {
+			t.Fatalf("%s: no macro [Foo_flags]", table.config)
+		}
+		if val != table.expected {
+			t.Fatalf("%s: expected Foo_flags=%q. got %q", table.config, table.expected, val)
+		}
+	}
+	if len(req.Diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", req.Diags)
+	}
+}
+
+// EOF
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Format is the kind of script generated for a package
+type Format string
+
+const (
+	AutoFormat Format = "auto" // hscript.yml, unless python is needed
+	YmlFormat  Format = "yml"  // hscript.yml
+	PyFormat   Format = "py"   // hscript.py
+	BothFormat Format = "both" // hscript.yml and hscript.py
+)
+
+var (
+	// g_format is the format of the packages with no override
+	g_format = AutoFormat
+	// g_pkg_formats holds the per-package overrides of g_format
+	g_pkg_formats = make(map[string]Format)
+)
+
+func parse_format(s string) (Format, error) {
+	switch f := Format(s); f {
+	case AutoFormat, YmlFormat, PyFormat, BothFormat:
+		return f, nil
+	}
+	return AutoFormat, fmt.Errorf("invalid format [%s] (expected auto, yml, py or both)", s)
+}
+
+// load_format_table reads a file of per-package formats.
+// each line holds a package, by name or by path, and its format:
+//
+//	# package             format
+//	AthenaKernel          py
+//	Control/CxxUtils      yml
+func load_format_table(fname string) (map[string]Format, error) {
+	tbl, err := read_table(fname, "a package and its format")
+	if err != nil {
+		return nil, err
+	}
+	formats := make(map[string]Format, len(tbl))
+	for k, v := range tbl {
+		formats[k], err = parse_format(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", fname, k, err)
+		}
+	}
+	return formats, err
+}
+
+// pkg_format returns the format requested for a package, and who requested
+// it.
+// overrides match the package path, or any of its trailing components.
+func pkg_format(pkg string) (Format, string) {
+	dirs := strings.Split(filepath.ToSlash(pkg), "/")
+	for i := range dirs {
+		if f, ok := g_pkg_formats[strings.Join(dirs[i:], "/")]; ok {
+			return f, "-format-map"
+		}
+	}
+	return g_format, "-format"
+}
+
+// py_reason is a construct only a hscript.py can describe
+type py_reason struct {
+	stmt Stmt // nil if not due to a statement
+	what string
+}
+
+// need_wscript records a construct only a hscript.py can describe
+func (r *Renderer) need_wscript(stmt Stmt, what string) {
+	r.why = append(r.why, py_reason{stmt: stmt, what: what})
+}
+
+// py_reasons describes the constructs only a hscript.py can describe
+func (r *Renderer) py_reasons() []string {
+	o := make([]string, 0, len(r.why))
+	for _, why := range r.why {
+		if why.stmt == nil {
+			o = append(o, why.what)
+			continue
+		}
+		o = append(o, fmt.Sprintf("%s at %v", why.what, r.req.Pos(why.stmt)))
+	}
+	return o
+}
+
+// format returns the formats of the scripts of the package, and why
+func (r *Renderer) format() ([]Format, string) {
+	f, origin := pkg_format(r.pkg.Package.Name)
+	switch f {
+	case AutoFormat:
+		if len(r.why) == 0 {
+			return []Format{YmlFormat}, "yml: no construct requires python"
+		}
+		why := r.py_reasons()
+		if len(why) > 3 {
+			return []Format{PyFormat}, fmt.Sprintf("py: %s (and %d more)", strings.Join(why[:3], ", "), len(why)-3)
+		}
+		return []Format{PyFormat}, "py: " + strings.Join(why, ", ")
+	case BothFormat:
+		return []Format{YmlFormat, PyFormat}, fmt.Sprintf("both: requested by %s", origin)
+	}
+	return []Format{f}, fmt.Sprintf("%s: requested by %s", f, origin)
+}
+
+// EOF
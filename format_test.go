@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadFormatTable(t *testing.T) {
+	tbl, err := load_format_table("testdata/formats.map")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := map[string]Format{
+		"Tools/Py": YmlFormat,
+		"Yml":      BothFormat,
+	}
+	if !reflect.DeepEqual(tbl, expected) {
+		t.Fatalf("expected %v. got %v", expected, tbl)
+	}
+
+	orig := g_pkg_formats
+	defer func() { g_pkg_formats = orig }()
+	g_pkg_formats = tbl
+	for _, table := range []struct {
+		pkg    string
+		format Format
+	}{
+		{"testdata/format/Tools/Py", YmlFormat},
+		{"Tools/Py", YmlFormat},
+		{"Py", AutoFormat},
+		{"Other/Yml", BothFormat},
+		{"Yml2", AutoFormat},
+	} {
+		if f, _ := pkg_format(table.pkg); f != table.format {
+			t.Fatalf("%s: expected format %v. got %v", table.pkg, table.format, f)
+		}
+	}
+}
+
+func TestRenderFormat(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "cmt2yml-")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(tmp)
+
+	defer func(f Format) { g_src_dir, g_out_dir, g_format = ".", "", f }(g_format)
+	g_src_dir, g_out_dir = "testdata/format", tmp
+
+	for _, table := range []struct {
+		format Format
+		pkg    string
+		files  []string
+		why    string
+	}{
+		{AutoFormat, "Yml", []string{"hscript.yml"}, "yml: no construct requires python"},
+		{
+			AutoFormat, "Py", []string{"hscript.py"},
+			"py: macro Py_cppflags (2 tag alternatives) at testdata/format/Tools/Py/cmt/requirements:3, " +
+				"macro_remove Py_cppflags at testdata/format/Tools/Py/cmt/requirements:5, " +
+				"make_fragment Py_gen at testdata/format/Tools/Py/cmt/requirements:6",
+		},
+		{YmlFormat, "Py", []string{"hscript.yml"}, "yml: requested by -format"},
+		{PyFormat, "Yml", []string{"hscript.py"}, "py: requested by -format"},
+		{BothFormat, "Yml", []string{"hscript.yml", "hscript.py"}, "both: requested by -format"},
+	} {
+		os.RemoveAll(filepath.Join(tmp, "Tools"))
+		g_format = table.format
+
+		req, err := parse_file(filepath.Join("testdata/format/Tools", table.pkg, "cmt/requirements"))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		r, err := NewRenderer(req)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		err = r.Render()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if _, why := r.format(); why != table.why {
+			t.Fatalf("%s (%v): expected reason %q. got %q", table.pkg, table.format, table.why, why)
+		}
+		files := []string{}
+		for _, fname := range []string{"hscript.yml", "hscript.py"} {
+			if path_exists(filepath.Join(tmp, "Tools", table.pkg, fname)) {
+				files = append(files, fname)
+			}
+		}
+		if !reflect.DeepEqual(files, table.files) {
+			t.Fatalf("%s (%v): expected files %v. got %v", table.pkg, table.format, table.files, files)
+		}
+
+		forced := false
+		for _, diag := range req.Diags {
+			forced = forced || strings.HasPrefix(diag.Msg, "hscript.yml requested but python is needed")
+		}
+		if forced != (table.format == YmlFormat) {
+			t.Fatalf("%s (%v): unexpected diagnostics %v", table.pkg, table.format, req.Diags)
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DepNode is a package of the walked tree
+type DepNode struct {
+	Name    string `json:"name"` // package path relative to the walked tree, e.g. Control/AthenaKernel
+	Version string `json:"version,omitempty"`
+	File    string `json:"file"`
+}
+
+// DepEdge is a use statement of a package
+type DepEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"` // package name, resolved to a node name when possible
+	Version  string `json:"version,omitempty"`
+	Offset   string `json:"offset,omitempty"`
+	Private  bool   `json:"private"`
+	Runtime  bool   `json:"runtime"` // -no_auto_imports
+	Resolved bool   `json:"resolved"`
+	Pos      Pos    `json:"-"`
+}
+
+// DepGraph is the graph of the use statements of a set of packages
+type DepGraph struct {
+	Nodes map[string]*DepNode
+	Edges []DepEdge
+}
+
+// NewDepGraph builds the dependency graph of the packages of the given
+// requirements files, located under the root directory
+func NewDepGraph(root string, reqs []*ReqFile) *DepGraph {
+	g := &DepGraph{Nodes: make(map[string]*DepNode, len(reqs))}
+	names := make(map[*ReqFile]string, len(reqs))
+	for _, req := range reqs {
+		name := dep_node_name(root, req.Filename)
+		node := &DepNode{Name: name, File: req.Filename, Version: req_version(req)}
+		g.Nodes[name] = node
+		names[req] = name
+	}
+
+	// index of the nodes by package name, for use statements with no
+	// (or a different) offset
+	bases := make(map[string][]string)
+	for name := range g.Nodes {
+		base := path.Base(name)
+		bases[base] = append(bases[base], name)
+	}
+
+	for _, req := range reqs {
+		for _, stmt := range req.Stmts {
+			switch x := stmt.(type) {
+			case *UsePkg:
+				edge := DepEdge{
+					From:    names[req],
+					To:      path.Join(x.Path, x.Package),
+					Version: x.Version,
+					Offset:  x.Path,
+					Private: req.Scope(x) == PrivateScope,
+					Runtime: str_is_in_slice(x.Switches, "-no_auto_imports"),
+					Pos:     req.Pos(x),
+				}
+				if _, ok := g.Nodes[edge.To]; ok {
+					edge.Resolved = true
+				} else if cands := bases[x.Package]; len(cands) == 1 {
+					edge.To = cands[0]
+					edge.Resolved = true
+				}
+				g.Edges = append(g.Edges, edge)
+			}
+		}
+	}
+	return g
+}
+
+// dep_node_name returns the name of the package of a requirements file:
+// its directory relative to root, without version directories
+func dep_node_name(root, fname string) string {
+	pkgdir := filepath.Dir(filepath.Dir(fname))
+	name, err := filepath.Rel(root, pkgdir)
+	if err != nil || name == "." || strings.HasPrefix(name, "..") {
+		abs, err := filepath.Abs(pkgdir)
+		if err != nil {
+			abs = pkgdir
+		}
+		name = filepath.Base(pkg_layout(filepath.Join(abs, "cmt", "requirements")).Name)
+	} else {
+		name, _ = strip_version_dirs(name)
+	}
+	return filepath.ToSlash(name)
+}
+
+// names returns the sorted names of the nodes
+func (g *DepGraph) names() []string {
+	names := make([]string, 0, len(g.Nodes))
+	for name := range g.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Unresolved returns the sorted names of the used packages which are not
+// part of the graph, with the packages using them
+func (g *DepGraph) Unresolved() map[string][]string {
+	users := make(map[string][]string)
+	for _, edge := range g.Edges {
+		if !edge.Resolved {
+			users[edge.To] = append(users[edge.To], edge.From)
+		}
+	}
+	for name := range users {
+		users[name] = str_unique(users[name])
+	}
+	return users
+}
+
+// succs returns the resolved dependencies of each package
+func (g *DepGraph) succs() map[string][]string {
+	succ := make(map[string][]string, len(g.Nodes))
+	for _, edge := range g.Edges {
+		if edge.Resolved {
+			succ[edge.From] = append(succ[edge.From], edge.To)
+		}
+	}
+	return succ
+}
+
+// sccs returns the strongly connected components of the graph, each
+// component being listed after the components it depends on.
+func (g *DepGraph) sccs() [][]string {
+	succ := g.succs()
+
+	// tarjan's strongly connected components algorithm
+	index := make(map[string]int, len(g.Nodes))
+	low := make(map[string]int, len(g.Nodes))
+	onstack := make(map[string]bool, len(g.Nodes))
+	stack := []string{}
+	sccs := [][]string{}
+
+	var visit func(n string)
+	visit = func(n string) {
+		index[n] = len(index)
+		low[n] = index[n]
+		stack = append(stack, n)
+		onstack[n] = true
+		for _, m := range succ[n] {
+			if _, seen := index[m]; !seen {
+				visit(m)
+				if low[m] < low[n] {
+					low[n] = low[m]
+				}
+			} else if onstack[m] && index[m] < low[n] {
+				low[n] = index[m]
+			}
+		}
+		if low[n] != index[n] {
+			return
+		}
+		scc := []string{}
+		for {
+			m := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onstack[m] = false
+			scc = append(scc, m)
+			if m == n {
+				break
+			}
+		}
+		sort.Strings(scc)
+		sccs = append(sccs, scc)
+	}
+
+	for _, n := range g.names() {
+		if _, seen := index[n]; !seen {
+			visit(n)
+		}
+	}
+	return sccs
+}
+
+// Cycles returns the dependency cycles of the graph, as the sorted lists
+// of the packages of each strongly connected component
+func (g *DepGraph) Cycles() [][]string {
+	succ := g.succs()
+	cycles := [][]string{}
+	for _, scc := range g.sccs() {
+		if len(scc) > 1 || str_is_in_slice(succ[scc[0]], scc[0]) {
+			cycles = append(cycles, scc)
+		}
+	}
+	sort.Sort(str_slices(cycles))
+	return cycles
+}
+
+// Levels returns the packages sorted in topological order, grouped in
+// levels: the packages of a level only depend on packages of the previous
+// levels, so they can be migrated (or built) in parallel.
+// the packages of a cycle are put together in the same level.
+func (g *DepGraph) Levels() [][]string {
+	succ := g.succs()
+	level := make(map[string]int, len(g.Nodes))
+	levels := [][]string{}
+	// sccs lists the components in dependency order
+	for _, scc := range g.sccs() {
+		lvl := 0
+		for _, n := range scc {
+			for _, m := range succ[n] {
+				if str_is_in_slice(scc, m) {
+					continue
+				}
+				if level[m]+1 > lvl {
+					lvl = level[m] + 1
+				}
+			}
+		}
+		for _, n := range scc {
+			level[n] = lvl
+		}
+		for len(levels) <= lvl {
+			levels = append(levels, []string{})
+		}
+		levels[lvl] = append(levels[lvl], scc...)
+	}
+	for _, lvl := range levels {
+		sort.Strings(lvl)
+	}
+	return levels
+}
+
+// DepBlocker is a package together with the number of packages which
+// depend on it, directly or not
+type DepBlocker struct {
+	Name       string `json:"name"`
+	Dependents int    `json:"dependents"`
+}
+
+// Blockers returns the packages which have dependents, the ones blocking
+// the most packages first
+func (g *DepGraph) Blockers() []DepBlocker {
+	preds := make(map[string][]string, len(g.Nodes))
+	for n, deps := range g.succs() {
+		for _, m := range deps {
+			preds[m] = append(preds[m], n)
+		}
+	}
+
+	blockers := []DepBlocker{}
+	for _, name := range g.names() {
+		seen := map[string]bool{name: true}
+		queue := []string{name}
+		for len(queue) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+			for _, m := range preds[n] {
+				if !seen[m] {
+					seen[m] = true
+					queue = append(queue, m)
+				}
+			}
+		}
+		if n := len(seen) - 1; n > 0 {
+			blockers = append(blockers, DepBlocker{Name: name, Dependents: n})
+		}
+	}
+	sort.Stable(dep_blockers(blockers))
+	return blockers
+}
+
+type dep_blockers []DepBlocker
+
+func (p dep_blockers) Len() int           { return len(p) }
+func (p dep_blockers) Less(i, j int) bool { return p[i].Dependents > p[j].Dependents }
+func (p dep_blockers) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type str_slices [][]string
+
+func (p str_slices) Len() int           { return len(p) }
+func (p str_slices) Less(i, j int) bool { return strings.Join(p[i], " ") < strings.Join(p[j], " ") }
+func (p str_slices) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// WriteDot writes the graph in graphviz format.
+// private dependencies are dashed, runtime ones dotted, and unresolved
+// packages grayed out.
+func (g *DepGraph) WriteDot(w io.Writer) error {
+	var err error
+	fmt.Fprintf(w, "digraph packages {\n")
+	for _, name := range g.names() {
+		node := g.Nodes[name]
+		fmt.Fprintf(w, "  %q [label=%q];\n", name, strings.TrimSpace(name+" "+node.Version))
+	}
+	unresolved := g.Unresolved()
+	for _, name := range str_unique(map_keys(unresolved)) {
+		fmt.Fprintf(w, "  %q [style=filled, fillcolor=lightgray];\n", name)
+	}
+	for _, edge := range g.Edges {
+		attrs := []string{}
+		if edge.Version != "" {
+			attrs = append(attrs, fmt.Sprintf("label=%q", edge.Version))
+		}
+		switch {
+		case edge.Runtime:
+			attrs = append(attrs, "style=dotted")
+		case edge.Private:
+			attrs = append(attrs, "style=dashed")
+		}
+		fmt.Fprintf(w, "  %q -> %q", edge.From, edge.To)
+		if len(attrs) > 0 {
+			fmt.Fprintf(w, " [%s]", strings.Join(attrs, ", "))
+		}
+		fmt.Fprintf(w, ";\n")
+	}
+	_, err = fmt.Fprintf(w, "}\n")
+	return err
+}
+
+// WriteJSON writes the graph, its cycles, its unresolved packages and its
+// topological order in JSON format
+func (g *DepGraph) WriteJSON(w io.Writer) error {
+	nodes := make([]*DepNode, 0, len(g.Nodes))
+	for _, name := range g.names() {
+		nodes = append(nodes, g.Nodes[name])
+	}
+	edges := g.Edges
+	if edges == nil {
+		edges = []DepEdge{}
+	}
+	data, err := json.MarshalIndent(struct {
+		Packages   []*DepNode          `json:"packages"`
+		Deps       []DepEdge           `json:"deps"`
+		Cycles     [][]string          `json:"cycles"`
+		Unresolved map[string][]string `json:"unresolved"`
+		Levels     [][]string          `json:"levels"`
+		Blockers   []DepBlocker        `json:"blockers"`
+	}{nodes, edges, g.Cycles(), g.Unresolved(), g.Levels(), g.Blockers()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// map_keys returns the keys of m
+func map_keys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// EOF
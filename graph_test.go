@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func load_graph(t *testing.T) *DepGraph {
+	reqs := []*ReqFile{}
+	for _, fname := range []string{
+		"testdata/graph/Other/D/cmt/requirements",
+		"testdata/graph/Tools/A/cmt/requirements",
+		"testdata/graph/Tools/B/cmt/requirements",
+		"testdata/graph/Tools/C/cmt/requirements",
+	} {
+		req, err := parse_file(fname)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		reqs = append(reqs, req)
+	}
+	return NewDepGraph("testdata/graph", reqs)
+}
+
+func TestDepGraph(t *testing.T) {
+	g := load_graph(t)
+
+	expected := []DepEdge{
+		{From: "Tools/A", To: "Tools/B", Version: "B-*", Offset: "Tools", Resolved: true},
+		{From: "Tools/A", To: "External/Boost", Version: "v*", Offset: "External", Runtime: true},
+		{From: "Tools/B", To: "Tools/C", Version: "C-00-*", Private: true, Resolved: true},
+		{From: "Tools/C", To: "Tools/A", Version: "A-*", Offset: "Tools", Resolved: true},
+		{From: "Tools/C", To: "Other/D", Version: "D-*", Offset: "Other", Runtime: true, Resolved: true},
+	}
+	if len(g.Edges) != len(expected) {
+		t.Fatalf("expected %d edges. got %d", len(expected), len(g.Edges))
+	}
+	for i, edge := range g.Edges {
+		edge.Pos = Pos{}
+		if !reflect.DeepEqual(edge, expected[i]) {
+			t.Fatalf("edge #%d: expected %+v. got %+v", i, expected[i], edge)
+		}
+	}
+	if v := g.Nodes["Tools/A"].Version; v != "A-00-01-00" {
+		t.Fatalf("expected version A-00-01-00. got %q", v)
+	}
+
+	cycles := g.Cycles()
+	if !reflect.DeepEqual(cycles, [][]string{{"Tools/A", "Tools/B", "Tools/C"}}) {
+		t.Fatalf("unexpected cycles: %v", cycles)
+	}
+
+	unresolved := g.Unresolved()
+	if !reflect.DeepEqual(unresolved, map[string][]string{"External/Boost": {"Tools/A"}}) {
+		t.Fatalf("unexpected unresolved packages: %v", unresolved)
+	}
+}
+
+func TestDepGraphDot(t *testing.T) {
+	g := load_graph(t)
+	buf := new(bytes.Buffer)
+	err := g.WriteDot(buf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := `digraph packages {
+  "Other/D" [label="Other/D"];
+  "Tools/A" [label="Tools/A A-00-01-00"];
+  "Tools/B" [label="Tools/B"];
+  "Tools/C" [label="Tools/C"];
+  "External/Boost" [style=filled, fillcolor=lightgray];
+  "Tools/A" -> "Tools/B" [label="B-*"];
+  "Tools/A" -> "External/Boost" [label="v*", style=dotted];
+  "Tools/B" -> "Tools/C" [label="C-00-*", style=dashed];
+  "Tools/C" -> "Tools/A" [label="A-*"];
+  "Tools/C" -> "Other/D" [label="D-*", style=dotted];
+}
+`
+	if buf.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestDepGraphLevels(t *testing.T) {
+	g := load_graph(t)
+
+	levels := g.Levels()
+	expected := [][]string{
+		{"Other/D"},
+		{"Tools/A", "Tools/B", "Tools/C"},
+	}
+	if !reflect.DeepEqual(levels, expected) {
+		t.Fatalf("expected levels %v. got %v", expected, levels)
+	}
+
+	blockers := g.Blockers()
+	expected_blockers := []DepBlocker{
+		{Name: "Other/D", Dependents: 3},
+		{Name: "Tools/A", Dependents: 2},
+		{Name: "Tools/B", Dependents: 2},
+		{Name: "Tools/C", Dependents: 2},
+	}
+	if !reflect.DeepEqual(blockers, expected_blockers) {
+		t.Fatalf("expected blockers %v. got %v", expected_blockers, blockers)
+	}
+}
+
+func TestDepGraphLevelsDAG(t *testing.T) {
+	g := &DepGraph{Nodes: make(map[string]*DepNode)}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		g.Nodes[name] = &DepNode{Name: name}
+	}
+	for _, edge := range [][2]string{
+		{"a", "b"}, {"a", "c"}, {"b", "d"}, {"c", "d"}, {"e", "d"}, {"a", "x"},
+	} {
+		_, ok := g.Nodes[edge[1]]
+		g.Edges = append(g.Edges, DepEdge{From: edge[0], To: edge[1], Resolved: ok})
+	}
+
+	levels := g.Levels()
+	expected := [][]string{{"d"}, {"b", "c", "e"}, {"a"}}
+	if !reflect.DeepEqual(levels, expected) {
+		t.Fatalf("expected levels %v. got %v", expected, levels)
+	}
+	if cycles := g.Cycles(); len(cycles) != 0 {
+		t.Fatalf("unexpected cycles: %v", cycles)
+	}
+
+	blockers := g.Blockers()
+	expected_blockers := []DepBlocker{
+		{Name: "d", Dependents: 4},
+		{Name: "b", Dependents: 1},
+		{Name: "c", Dependents: 1},
+	}
+	if !reflect.DeepEqual(blockers, expected_blockers) {
+		t.Fatalf("expected blockers %v. got %v", expected_blockers, blockers)
+	}
+}
+
+// EOF
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// PkgLayout describes where a package lives on disk. CMT packages come in
+// two flavours:
+//
+//	Offset/Pkg/cmt/requirements               (without_version_directory)
+//	Offset/Pkg/Pkg-00-01-02/cmt/requirements  (with_version_directory)
+//
+// and container packages may hold other (possibly versioned) packages:
+//
+//	Cont/Cont-00-00-01/Pkg/Pkg-00-01-02/cmt/requirements
+type PkgLayout struct {
+	Dir       string // directory holding the cmt directory
+	Name      string // package path, without version directories
+	Version   string // version directory, or content of cmt/version.cmt
+	Versioned bool   // whether the package lives in a version directory
+}
+
+// matches the CMT-style version directories, e.g. v1r2p3
+var g_cmt_version_re = regexp.MustCompile(`^v\d+(r\d+)?(p\d+)?$`)
+
+// matches the version numbers of the ATLAS-style version directories,
+// e.g. the 00-01-02 of Pkg-00-01-02
+var g_pkg_version_re = regexp.MustCompile(`^\d+(-\d+)+`)
+
+// is_version_dir returns whether dir is the version directory of the
+// package pkg
+func is_version_dir(pkg, dir string) bool {
+	if strings.HasPrefix(dir, pkg+"-") {
+		return g_pkg_version_re.MatchString(dir[len(pkg)+1:])
+	}
+	return g_cmt_version_re.MatchString(dir)
+}
+
+// pkg_layout detects the layout of the package of a requirements file
+func pkg_layout(fname string) PkgLayout {
+	layout := PkgLayout{Dir: filepath.Dir(filepath.Dir(fname))}
+	layout.Name, layout.Version = strip_version_dirs(layout.Dir)
+	layout.Versioned = layout.Version != ""
+	if !layout.Versioned {
+		layout.Version = read_version_cmt(filepath.Join(filepath.Dir(fname), "version.cmt"))
+	}
+	return layout
+}
+
+// strip_version_dirs removes the version directories from a package path.
+// It also returns the version directory of the package itself, if any.
+func strip_version_dirs(path string) (string, string) {
+	version := ""
+	dirs := strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+	name := make([]string, 0, len(dirs))
+	for i, dir := range dirs {
+		if i > 0 && dirs[i-1] != "" && dirs[i-1] != "." && dirs[i-1] != ".." &&
+			is_version_dir(dirs[i-1], dir) {
+			if i == len(dirs)-1 {
+				version = dir
+			}
+			continue
+		}
+		name = append(name, dir)
+	}
+	return filepath.FromSlash(strings.Join(name, "/")), version
+}
+
+// read_version_cmt returns the version stored in a cmt/version.cmt file,
+// or "" if there is none
+func read_version_cmt(fname string) string {
+	f, err := os.Open(fname)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// req_version returns the version of the package of a requirements file:
+// the one of its version statement or, if none, the one of its layout
+func req_version(req *ReqFile) string {
+	version := ""
+	for _, stmt := range req.Stmts {
+		if x, ok := stmt.(*Version); ok {
+			version = x.Value
+		}
+	}
+	if version == "" {
+		version = pkg_layout(req.Filename).Version
+	}
+	return version
+}
+
+// EOF
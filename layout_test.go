@@ -0,0 +1,135 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPkgLayout(t *testing.T) {
+	for _, table := range []struct {
+		fname    string
+		expected PkgLayout
+	}{
+		{
+			fname: "Control/AthenaKernel/cmt/requirements",
+			expected: PkgLayout{
+				Dir:  "Control/AthenaKernel",
+				Name: "Control/AthenaKernel",
+			},
+		},
+		{
+			fname: "Control/AthenaKernel/AthenaKernel-00-55-13/cmt/requirements",
+			expected: PkgLayout{
+				Dir:       "Control/AthenaKernel/AthenaKernel-00-55-13",
+				Name:      "Control/AthenaKernel",
+				Version:   "AthenaKernel-00-55-13",
+				Versioned: true,
+			},
+		},
+		{
+			fname: "/opt/GaudiKernel/v27r1p2/cmt/requirements",
+			expected: PkgLayout{
+				Dir:       "/opt/GaudiKernel/v27r1p2",
+				Name:      "/opt/GaudiKernel",
+				Version:   "v27r1p2",
+				Versioned: true,
+			},
+		},
+		{
+			// container package holding a versioned package
+			fname: "Cont/Cont-00-00-01/Pkg/Pkg-01-00-00/cmt/requirements",
+			expected: PkgLayout{
+				Dir:       "Cont/Cont-00-00-01/Pkg/Pkg-01-00-00",
+				Name:      "Cont/Pkg",
+				Version:   "Pkg-01-00-00",
+				Versioned: true,
+			},
+		},
+		{
+			// not a version directory
+			fname: "Tools/Tools-Ext/cmt/requirements",
+			expected: PkgLayout{
+				Dir:  "Tools/Tools-Ext",
+				Name: "Tools/Tools-Ext",
+			},
+		},
+		{
+			fname: "testdata/layout/Tools/Bar/cmt/requirements",
+			expected: PkgLayout{
+				Dir:     "testdata/layout/Tools/Bar",
+				Name:    "testdata/layout/Tools/Bar",
+				Version: "Bar-01-02-03",
+			},
+		},
+	} {
+		fname := filepath.FromSlash(table.fname)
+		expected := table.expected
+		expected.Dir = filepath.FromSlash(expected.Dir)
+		expected.Name = filepath.FromSlash(expected.Name)
+		layout := pkg_layout(fname)
+		if layout != expected {
+			t.Fatalf("%s: expected %+v. got %+v", table.fname, expected, layout)
+		}
+	}
+}
+
+func TestPkgLayoutRender(t *testing.T) {
+	for _, table := range []struct {
+		fname   string
+		name    string
+		version string
+	}{
+		{
+			fname:   "testdata/layout/Tools/Foo/Foo-00-01-02/cmt/requirements",
+			name:    "testdata/layout/Tools/Foo",
+			version: "Foo-00-01-02",
+		},
+		{
+			fname:   "testdata/layout/Tools/Bar/cmt/requirements",
+			name:    "testdata/layout/Tools/Bar",
+			version: "Bar-01-02-03",
+		},
+	} {
+		req, err := parse_file(table.fname)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		r, err := NewRenderer(req)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		err = r.analyze()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if r.pkg.Package.Name != table.name {
+			t.Fatalf("expected package name %q. got %q", table.name, r.pkg.Package.Name)
+		}
+		if string(r.pkg.Package.Version) != table.version {
+			t.Fatalf("expected package version %q. got %q", table.version, r.pkg.Package.Version)
+		}
+	}
+}
+
+func TestPkgLayoutGraph(t *testing.T) {
+	reqs := []*ReqFile{}
+	for _, fname := range []string{
+		"testdata/layout/Tools/Bar/cmt/requirements",
+		"testdata/layout/Tools/Foo/Foo-00-01-02/cmt/requirements",
+	} {
+		req, err := parse_file(fname)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		reqs = append(reqs, req)
+	}
+	g := NewDepGraph("testdata/layout", reqs)
+	if node, ok := g.Nodes["Tools/Foo"]; !ok || node.Version != "Foo-00-01-02" {
+		t.Fatalf("unexpected Tools/Foo node: %+v", node)
+	}
+	if len(g.Edges) != 1 || !g.Edges[0].Resolved || g.Edges[0].To != "Tools/Foo" {
+		t.Fatalf("unexpected edges: %+v", g.Edges)
+	}
+}
+
+// EOF
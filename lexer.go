@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+)
+
+// lexer splits a (logical) requirements line into tokens, following the
+// quoting rules of CMT:
+//   - tokens are separated by blanks (spaces and tabs)
+//   - "..." and '...' delimit a single token. the quotes are removed and
+//     leading/trailing blanks are trimmed
+//   - inside a quoted string, a backslash escapes the next quote character
+//   - quotes appearing in the middle of a word (e.g. key="some value")
+//     are kept verbatim, quotes included
+//   - $(...) and ${...} references are never split, even if they hold blanks
+//
+// comments are expected to have been removed beforehand (see find_comment.)
+type lexer struct {
+	data []byte
+	pos  int
+}
+
+func newLexer(data []byte) *lexer {
+	return &lexer{data: data}
+}
+
+func is_blank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func is_quote(c byte) bool {
+	return c == '"' || c == '\''
+}
+
+// tokens returns all the tokens of the line
+func (l *lexer) tokens() []string {
+	toks := []string{}
+	for {
+		tok, ok := l.next()
+		if !ok {
+			break
+		}
+		toks = append(toks, tok)
+	}
+	return toks
+}
+
+// next returns the next token of the line, or false at end of line
+func (l *lexer) next() (string, bool) {
+	l.skip_blanks()
+	if l.pos >= len(l.data) {
+		return "", false
+	}
+
+	tok := []byte{}
+	if is_quote(l.data[l.pos]) {
+		str := l.scan_quoted()
+		tok = append(tok, bytes.Trim(str, " \t")...)
+	}
+	tok = append(tok, l.scan_word()...)
+	return string(tok), true
+}
+
+func (l *lexer) skip_blanks() {
+	for l.pos < len(l.data) && is_blank(l.data[l.pos]) {
+		l.pos++
+	}
+}
+
+// scan_quoted consumes a quoted string and returns its unquoted and
+// unescaped content.
+// an unterminated string extends up to the end of the line.
+func (l *lexer) scan_quoted() []byte {
+	q := l.data[l.pos]
+	l.pos++
+	out := []byte{}
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case c == '\\' && l.pos+1 < len(l.data) && is_quote(l.data[l.pos+1]):
+			out = append(out, l.data[l.pos+1])
+			l.pos += 2
+		case c == q:
+			l.pos++
+			return out
+		default:
+			out = append(out, c)
+			l.pos++
+		}
+	}
+	return out
+}
+
+// scan_word consumes a bare word up to the next blank and returns it
+// verbatim.
+func (l *lexer) scan_word() []byte {
+	beg := l.pos
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case is_blank(c):
+			return l.data[beg:l.pos]
+		case is_quote(c):
+			l.skip_quoted()
+		case c == '$' && l.pos+1 < len(l.data) &&
+			(l.data[l.pos+1] == '(' || l.data[l.pos+1] == '{'):
+			l.skip_reference()
+		default:
+			l.pos++
+		}
+	}
+	return l.data[beg:l.pos]
+}
+
+// skip_quoted moves past a quoted string embedded in a word
+func (l *lexer) skip_quoted() {
+	q := l.data[l.pos]
+	l.pos++
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case c == '\\' && l.pos+1 < len(l.data):
+			l.pos += 2
+		case c == q:
+			l.pos++
+			return
+		default:
+			l.pos++
+		}
+	}
+}
+
+// skip_reference moves past a (possibly nested) $(...) or ${...} reference
+func (l *lexer) skip_reference() {
+	stack := []byte{}
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case c == '$' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '(':
+			stack = append(stack, ')')
+			l.pos += 2
+			continue
+		case c == '$' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '{':
+			stack = append(stack, '}')
+			l.pos += 2
+			continue
+		case c == stack[len(stack)-1]:
+			stack = stack[:len(stack)-1]
+		}
+		l.pos++
+		if len(stack) == 0 {
+			return
+		}
+	}
+}
+
+// find_comment returns the index of the comment starting in a physical
+// line, or -1 if there is none.
+// a comment starts with a '#' at the beginning of a word, outside of any
+// quoted string: '#' characters inside quotes are kept.
+// quote is the quote character left open by the previous (continued)
+// line, or 0. find_comment returns the quote left open at the end of
+// this line.
+func find_comment(data []byte, quote byte) (int, byte) {
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case is_quote(c):
+			quote = c
+		case c == '#' && (i == 0 || is_blank(data[i-1])):
+			return i, quote
+		}
+	}
+	return -1, quote
+}
+
+// EOF
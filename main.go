@@ -3,8 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func handle_err(err error) {
@@ -14,11 +16,108 @@ func handle_err(err error) {
 }
 
 var g_profile_name = flag.String("profile", "atlasoff", "name of the profile translator to use")
+var g_eval_tags = flag.String("eval-tags", "", "comma-separated list of tags (e.g. x86_64-slc6-gcc47-opt) to evaluate the macros of each package with")
+var g_graph_dot = flag.String("graph-dot", "", "write the dependency graph of the packages to this graphviz file, instead of converting them")
+var g_graph_json = flag.String("graph-json", "", "write the dependency graph of the packages to this JSON file, instead of converting them")
+var g_order = flag.Bool("order", false, "print the migration order of the packages and the ones blocking the most dependents, instead of converting them")
+var g_tag_map = flag.String("tag-map", "", "file of CMT to hwaf tag translations, overriding the ones of the profile")
+var g_var_map = flag.String("var-map", "", "file of CMT to hwaf built-in variable rewrites, overriding the ones of the profile")
+var g_dry_run_flag = flag.Bool("dry-run", false, "print the scripts which would be generated, instead of writing them into the tree")
+var g_diff_flag = flag.Bool("diff", false, "print the differences between the scripts which would be generated and the ones in the tree, instead of writing them")
+var g_dry_run_out = flag.String("dry-run-out", "", "write the output of -dry-run or -diff to this file instead of stdout")
+var g_out_dir_flag = flag.String("o", "", "write the scripts into a tree mirroring the converted one under this directory, instead of next to their requirements file")
+var g_format_flag = flag.String("format", "auto", "format of the generated scripts: auto (hscript.yml unless python is needed), yml, py or both")
+var g_format_map = flag.String("format-map", "", "file of per-package formats, overriding -format")
+var g_policy_dirs path_list
 
-func main() {
-	fmt.Printf("::: hwaf-cmt2yml\n")
+func init() {
+	flag.Var(&g_policy_dirs, "policy", "directory of requirements files to load patterns from (may be repeated)")
+}
 
+// write_dep_graph reports the cycles and unresolved packages of the
+// dependency graph, its migration order if requested, and writes it to
+// the requested files.
+// It returns false if the graph has cycles.
+func write_dep_graph(g *DepGraph) bool {
+	unresolved := g.Unresolved()
+	for _, name := range str_unique(map_keys(unresolved)) {
+		logf("** unresolved package [%s] (used by %s)\n", name, strings.Join(unresolved[name], ", "))
+	}
+	cycles := g.Cycles()
+	for _, cycle := range cycles {
+		logf("**err: dependency cycle: %s\n", strings.Join(cycle, ", "))
+	}
+	logf(">>> packages: %d, deps: %d, unresolved: %d, cycles: %d\n",
+		len(g.Nodes), len(g.Edges), len(unresolved), len(cycles),
+	)
+
+	if *g_order {
+		levels := g.Levels()
+		logf(">>> migration order (%d levels):\n", len(levels))
+		for i, lvl := range levels {
+			logf("level %d: %s\n", i, strings.Join(lvl, " "))
+		}
+		blockers := g.Blockers()
+		if len(blockers) > 10 {
+			blockers = blockers[:10]
+		}
+		logf(">>> packages blocking the most dependents:\n")
+		for _, b := range blockers {
+			logf("%6d %s\n", b.Dependents, b.Name)
+		}
+	}
+
+	for _, out := range []struct {
+		fname string
+		write func(w io.Writer) error
+	}{
+		{*g_graph_dot, g.WriteDot},
+		{*g_graph_json, g.WriteJSON},
+	} {
+		if out.fname == "" {
+			continue
+		}
+		f, err := os.Create(out.fname)
+		handle_err(err)
+		err = out.write(f)
+		handle_err(err)
+		err = f.Close()
+		handle_err(err)
+		logf(">>> wrote [%s]\n", out.fname)
+	}
+	return len(cycles) == 0
+}
+
+// report_diags prints the problems found in a requirements file
+func report_diags(req *ReqFile) {
+	for _, diag := range req.Diags {
+		if diag.Warn {
+			logf("**warn: %v\n", diag)
+		} else {
+			logf("**err: %v\n", diag)
+		}
+	}
+}
+
+// path_list is a flag.Value collecting the values of a repeated flag
+type path_list []string
+
+func (p *path_list) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *path_list) Set(v string) error {
+	*p = append(*p, v)
+	return nil
+}
+
+func main() {
 	flag.Parse()
+	if *g_dry_run_flag || *g_diff_flag {
+		g_log = os.Stderr
+	}
+	logf("::: hwaf-cmt2yml\n")
+
 	ok := false
 	g_profile, ok = g_profiles[*g_profile_name]
 	if !ok {
@@ -35,6 +134,61 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *g_tag_map != "" {
+		tbl, err := load_tag_table(*g_tag_map)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cmt2yml: %v\n", err)
+			os.Exit(1)
+		}
+		g_profile.tags = new_tag_table(g_profile.tags, tbl)
+	}
+
+	if *g_var_map != "" {
+		tbl, err := load_var_table(*g_var_map)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cmt2yml: %v\n", err)
+			os.Exit(1)
+		}
+		g_profile.vars = new_var_table(g_profile.vars, tbl)
+	}
+
+	{
+		format, err := parse_format(*g_format_flag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cmt2yml: %v\n", err)
+			os.Exit(1)
+		}
+		g_format = format
+	}
+
+	if *g_format_map != "" {
+		tbl, err := load_format_table(*g_format_map)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cmt2yml: %v\n", err)
+			os.Exit(1)
+		}
+		g_pkg_formats = tbl
+	}
+
+	if *g_dry_run_flag && *g_diff_flag {
+		fmt.Fprintf(os.Stderr, "cmt2yml: -dry-run and -diff are mutually exclusive\n")
+		os.Exit(1)
+	}
+	if *g_dry_run_flag || *g_diff_flag {
+		w := os.Stdout
+		if *g_dry_run_out != "" {
+			f, err := os.Create(*g_dry_run_out)
+			handle_err(err)
+			defer f.Close()
+			w = f
+		}
+		if *g_dry_run_flag {
+			g_dry_run = new_dry_run(w)
+		} else {
+			g_diff = new_diff(w)
+		}
+	}
+
 	dir := "."
 	switch len(flag.Args()) {
 	case 0:
@@ -48,38 +202,60 @@ func main() {
 	var err error
 	//dir, err = filepath.Abs(dir)
 	handle_err(err)
+	g_src_dir = dir
+	g_out_dir = *g_out_dir_flag
 
 	fnames := []string{}
-	fmt.Printf(">>> dir=%q\n", dir)
+	logf(">>> dir=%q\n", dir)
 	if !path_exists(dir) {
-		fmt.Printf("** no such file or directory [%s]\n", dir)
+		logf("** no such file or directory [%s]\n", dir)
 		os.Exit(1)
 	}
 
+	// all the requirements files to load patterns from
+	allnames := []string{}
+	// all the requirements files of the walked tree
+	walked := []string{}
+	// all the cmt/project.cmt files of the walked tree
+	projects := []string{}
+
 	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
 		//fmt.Printf("::> [%s]...\n", path)
+		if filepath.Base(path) == "project.cmt" && filepath.Base(filepath.Dir(path)) == "cmt" {
+			projects = append(projects, filepath.Clean(path))
+			logf("::> [%s]...\n", path)
+			return err
+		}
 		if filepath.Base(path) != "requirements" {
 			return nil
 		} else {
+			allnames = append(allnames, filepath.Clean(path))
+			walked = append(walked, filepath.Clean(path))
 			// check whether a non-automatically generated hscript.py or hscript.yml
 			// already exist
 			pkgdir := filepath.Dir(filepath.Dir(path))
 			usr_file := false
+			usr_fname := ""
 			if path_exists(filepath.Join(pkgdir, "hscript.yml")) {
 				usr_file = is_user_file(filepath.Join(pkgdir, "hscript.yml"))
 				if usr_file {
-					fmt.Printf("** discard [%s] (user-written hscript.yml)\n", pkgdir)
+					usr_fname = filepath.Join(pkgdir, "hscript.yml")
+					logf("** discard [%s] (user-written hscript.yml)\n", pkgdir)
 				}
 			}
 			if path_exists(filepath.Join(pkgdir, "hscript.py")) {
 				usr_file = is_user_file(filepath.Join(pkgdir, "hscript.py"))
 				if usr_file {
-					fmt.Printf("** discard [%s] (user-written hscript.py)\n", pkgdir)
+					usr_fname = filepath.Join(pkgdir, "hscript.py")
+					logf("** discard [%s] (user-written hscript.py)\n", pkgdir)
 				}
 			}
+			if usr_file {
+				err = skip_script(pkgdir, usr_fname, "user-written file")
+			}
 			if !usr_file {
-				fnames = append(fnames, path)
-				fmt.Printf("::> [%s]...\n", path)
+				fnames = append(fnames, filepath.Clean(path))
+				logf("::> [%s]...\n", path)
 			}
 
 		}
@@ -87,64 +263,165 @@ func main() {
 	})
 	handle_err(err)
 
-	if len(fnames) < 1 {
-		fmt.Printf(":: hwaf-cmt2yml: no requirements file under [%s]\n", dir)
+	graph := *g_graph_dot != "" || *g_graph_json != "" || *g_order
+	if graph {
+		fnames = walked
+		projects = nil
+	}
+
+	if len(fnames) < 1 && len(projects) < 1 {
+		logf(":: hwaf-cmt2yml: no requirements or project file under [%s]\n", dir)
 		os.Exit(0)
 	}
 
+	for _, pdir := range g_policy_dirs {
+		if !path_exists(pdir) {
+			logf("** no such policy directory [%s]\n", pdir)
+			os.Exit(1)
+		}
+		err = filepath.Walk(pdir, func(path string, fi os.FileInfo, err error) error {
+			if filepath.Base(path) == "requirements" {
+				allnames = append(allnames, filepath.Clean(path))
+			}
+			return err
+		})
+		handle_err(err)
+	}
+	allnames = str_unique(allnames)
+
 	type Response struct {
-		req *ReqFile
-		err error
+		fname string
+		req   *ReqFile
+		err   error
 	}
 
 	// limit how many goroutines we have in flight
 	// so we don't max out the number of open file descriptors
 	throttle := make(chan struct{}, 100)
+
+	// first phase: parse all the requirements files
 	ch := make(chan Response)
-	for _, fname := range fnames {
+	for _, fname := range allnames {
 		go func(fname string) {
 			throttle <- struct{}{}
 			reqfile, err := parse_file(fname)
-			if err != nil {
-				<-throttle
-				ch <- Response{
-					reqfile,
-					fmt.Errorf("(parse) err w/ file [%s]: %v", fname, err),
-				}
-				return
+			<-throttle
+			ch <- Response{fname, reqfile, err}
+		}(fname)
+	}
+
+	reqs := make(map[string]*ReqFile, len(allnames))
+	errs := make(map[string]error)
+	for range allnames {
+		resp := <-ch
+		reqs[resp.fname] = resp.req
+		if resp.err != nil {
+			errs[resp.fname] = resp.err
+		}
+	}
+
+	// patterns are registered in a stable order, so the winner of
+	// conflicting definitions does not depend on scheduling
+	for _, fname := range allnames {
+		if req := reqs[fname]; req != nil {
+			g_patterns.Add(req)
+		}
+	}
+	logf(">>> patterns: %d\n", g_patterns.Len())
+
+	// problems of the files only loaded for their patterns are not fatal
+	converted := make(map[string]bool, len(fnames))
+	for _, fname := range fnames {
+		converted[fname] = true
+	}
+	for _, fname := range allnames {
+		if converted[fname] {
+			continue
+		}
+		if err := errs[fname]; err != nil {
+			logf("**warn: %s: %v\n", fname, err)
+		}
+		if req := reqs[fname]; req != nil {
+			for _, diag := range req.Diags {
+				logf("**warn: %v\n", diag)
 			}
-			err = render_script(reqfile)
+		}
+	}
+
+	if graph {
+		greqs := make([]*ReqFile, 0, len(walked))
+		for _, fname := range str_unique(walked) {
+			if err := errs[fname]; err != nil {
+				logf("**err: %s: %v\n", fname, err)
+				continue
+			}
+			greqs = append(greqs, reqs[fname])
+		}
+		if !write_dep_graph(NewDepGraph(dir, greqs)) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// second phase: convert the requirements files of the walked tree
+	for _, fname := range fnames {
+		go func(fname string) {
+			reqfile := reqs[fname]
+			err := errs[fname]
 			if err != nil {
-				<-throttle
-				ch <- Response{
-					reqfile,
-					fmt.Errorf("(render) err w/ file [%s]: %v", fname, err),
-				}
+				ch <- Response{fname, reqfile, err}
 				return
 			}
+			throttle <- struct{}{}
+			err = render_script(reqfile)
 			<-throttle
-			ch <- Response{reqfile, nil}
+			ch <- Response{fname, reqfile, err}
 		}(fname)
 	}
 
 	sum := 0
 	allgood := true
-loop:
-	for {
-		select {
-		case resp := <-ch:
-			sum += 1
-			if resp.err != nil {
-				fmt.Printf("**err: %v\n", resp.err)
-				allgood = false
+	for sum < len(fnames) {
+		resp := <-ch
+		sum += 1
+		if resp.err != nil {
+			logf("**err: %v\n", resp.err)
+			allgood = false
+		}
+		if resp.req != nil {
+			if *g_eval_tags != "" {
+				eval_macros(g_log, resp.req, str_split(*g_eval_tags, ","))
 			}
-			if sum == len(fnames) {
-				close(ch)
-				close(throttle)
-				break loop
+			report_diags(resp.req)
+			if resp.req.IsPartial() {
+				allgood = false
 			}
 		}
 	}
+	close(ch)
+	close(throttle)
+
+	// projects are few: convert them one after the other
+	for _, fname := range projects {
+		proj, err := parse_project(fname)
+		if err == nil {
+			err = render_project(proj)
+		}
+		if err != nil {
+			logf("**err: %v\n", err)
+			allgood = false
+			continue
+		}
+		report_diags(proj.req)
+		if proj.req.IsPartial() {
+			allgood = false
+		}
+	}
+
+	if g_diff != nil {
+		err = g_diff.summary(g_log)
+		handle_err(err)
+	}
 
 	if !allgood {
 		os.Exit(1)
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// g_log receives the progress messages.
+// they go to stderr during a dry run or a diff, so their output can be
+// piped to other tools.
+var g_log io.Writer = os.Stdout
+
+// logf writes a progress message
+func logf(format string, args ...interface{}) {
+	fmt.Fprintf(g_log, format, args...)
+}
+
+var (
+	// g_src_dir is the root of the converted tree
+	g_src_dir = "."
+	// g_out_dir is the root of the tree the scripts are written into,
+	// mirroring the converted one.
+	// scripts are written next to their requirements file if it is empty.
+	g_out_dir = ""
+)
+
+// out_path returns where the script of the source tree fname goes
+func out_path(fname string) (string, error) {
+	if g_out_dir == "" {
+		return fname, nil
+	}
+	rel, err := filepath.Rel(g_src_dir, fname)
+	if err != nil {
+		return fname, err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fname, fmt.Errorf("[%s] is not under [%s]", fname, g_src_dir)
+	}
+	return filepath.Join(g_out_dir, rel), err
+}
+
+// write_script writes a generated script to fname (or to its mirror under
+// the output directory) or, during a dry run or a diff, hands it over to
+// them.
+// why explains the choice of the script format.
+func write_script(pkgdir, fname, why string, data []byte) error {
+	var err error
+	fname, err = out_path(fname)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case g_dry_run != nil:
+		return g_dry_run.script(pkgdir, fname, why, data)
+	case g_diff != nil:
+		return g_diff.script(pkgdir, fname, data)
+	}
+
+	err = os.MkdirAll(filepath.Dir(fname), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	return err
+}
+
+// skip_script reports a script which is not generated, and why
+func skip_script(pkgdir, fname, why string) error {
+	var err error
+	switch {
+	case g_dry_run != nil:
+		return g_dry_run.skip(pkgdir, fname, why)
+	case g_diff != nil:
+		g_diff.skip(pkgdir)
+	}
+	return err
+}
+
+// dry_run_t collects the scripts of a dry run into a single stream,
+// package after package, instead of writing them into the tree
+type dry_run_t struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// g_dry_run is the stream of the dry run, if any
+var g_dry_run *dry_run_t
+
+func new_dry_run(w io.Writer) *dry_run_t {
+	return &dry_run_t{w: w}
+}
+
+// script writes the script which would be written to fname, and why
+func (d *dry_run_t) script(pkgdir, fname, why string, data []byte) error {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "### package: %s\n", pkgdir)
+	fmt.Fprintf(buf, "### file:    %s (%s)\n", fname, why)
+	buf.Write(data)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+	return d.write(buf.Bytes())
+}
+
+// skip reports a package whose script would not be written, and why
+func (d *dry_run_t) skip(pkgdir, fname, why string) error {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "### package: %s\n", pkgdir)
+	fmt.Fprintf(buf, "### skip:    %s (%s)\n\n", fname, why)
+	return d.write(buf.Bytes())
+}
+
+// write writes a whole package at once, so packages rendered concurrently
+// do not interleave
+func (d *dry_run_t) write(data []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := d.w.Write(data)
+	return err
+}
+
+// diff_t compares the generated scripts with the ones already in the
+// tree, instead of overwriting them
+type diff_t struct {
+	mu sync.Mutex
+	w  io.Writer
+
+	changed   []string // packages whose script would change
+	unchanged []string // packages whose script is up-to-date
+	added     []string // packages with no script yet
+	skipped   []string // packages with a user-written script
+}
+
+// g_diff is the diff in progress, if any
+var g_diff *diff_t
+
+func new_diff(w io.Writer) *diff_t {
+	return &diff_t{w: w}
+}
+
+// script writes the unified diff between fname and the script which would
+// replace it
+func (d *diff_t) script(pkgdir, fname string, data []byte) error {
+	aname := fname
+	exists := true
+	old, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		aname = "/dev/null"
+		exists, err = false, nil
+	}
+	if err != nil {
+		return err
+	}
+	diff := unified_diff(aname, fname, old, data)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case !exists:
+		d.added = append(d.added, pkgdir)
+	case diff == nil:
+		d.unchanged = append(d.unchanged, pkgdir)
+		return err
+	default:
+		d.changed = append(d.changed, pkgdir)
+	}
+	_, err = d.w.Write(diff)
+	return err
+}
+
+// skip records a package whose script is user-written
+func (d *diff_t) skip(pkgdir string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.skipped = append(d.skipped, pkgdir)
+}
+
+// summary writes the lists of changed and new packages, and how many
+// packages are unchanged or skipped
+func (d *diff_t) summary(w io.Writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, pkgs := range []struct {
+		tag  string
+		pkgs []string
+	}{
+		{"changed", d.changed},
+		{"new", d.added},
+	} {
+		sort.Strings(pkgs.pkgs)
+		for _, pkg := range pkgs.pkgs {
+			fmt.Fprintf(w, "%-8s %s\n", pkgs.tag+":", pkg)
+		}
+	}
+	_, err := fmt.Fprintf(w, ">>> diff: %d changed, %d unchanged, %d new, %d skipped (user-written)\n",
+		len(d.changed), len(d.unchanged), len(d.added), len(d.skipped),
+	)
+	return err
+}
+
+// EOF
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	const fname = "testdata/vars/Tools/Foo/cmt/requirements"
+	req, err := parse_file(fname)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	buf := new(bytes.Buffer)
+	g_dry_run = new_dry_run(buf)
+	defer func() { g_dry_run = nil }()
+
+	err = render_script(req)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	pkgdir := filepath.Dir(filepath.Dir(fname))
+	out := filepath.Join(pkgdir, "hscript.yml")
+	if path_exists(out) {
+		t.Fatalf("dry run wrote [%s]", out)
+	}
+	header := "### package: " + pkgdir + "\n" +
+		"### file:    " + out + " (yml: no construct requires python)\n" +
+		"## automatically generated by cmt2yml\n"
+	if !strings.HasPrefix(buf.String(), header) {
+		t.Fatalf("expected output to start with:\n%s\ngot:\n%s", header, buf.String())
+	}
+}
+
+func TestDryRunReason(t *testing.T) {
+	const fname = "testdata/format/Tools/Py/cmt/requirements"
+	defer func(f Format) { g_format, g_dry_run = f, nil }(g_format)
+
+	for _, table := range []struct {
+		format Format
+		file   string
+	}{
+		{
+			AutoFormat,
+			"hscript.py (py: macro Py_cppflags (2 tag alternatives) at " + fname + ":3, " +
+				"macro_remove Py_cppflags at " + fname + ":5, " +
+				"make_fragment Py_gen at " + fname + ":6)",
+		},
+		{YmlFormat, "hscript.yml (yml: requested by -format)"},
+		{PyFormat, "hscript.py (py: requested by -format)"},
+	} {
+		req, err := parse_file(fname)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		buf := new(bytes.Buffer)
+		g_format = table.format
+		g_dry_run = new_dry_run(buf)
+
+		err = render_script(req)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		header := "### file:    " + filepath.Join("testdata/format/Tools/Py", table.file) + "\n"
+		if !strings.Contains(buf.String(), header) {
+			t.Fatalf("%v: expected output to contain:\n%s\ngot:\n%s", table.format, header, buf.String())
+		}
+	}
+}
+
+func TestOutDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "cmt2yml-")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer os.RemoveAll(tmp)
+
+	defer func() { g_src_dir, g_out_dir = ".", "" }()
+	g_src_dir, g_out_dir = "testdata/vars", tmp
+
+	const fname = "testdata/vars/Tools/Foo/cmt/requirements"
+	req, err := parse_file(fname)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = render_script(req)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if path_exists("testdata/vars/Tools/Foo/hscript.yml") {
+		t.Fatalf("script written into the source tree")
+	}
+	if !path_exists(filepath.Join(tmp, "Tools", "Foo", "hscript.yml")) {
+		t.Fatalf("script not written into the output tree")
+	}
+
+	_, err = out_path("testdata/layout/Tools/Bar/hscript.yml")
+	if err == nil {
+		t.Fatalf("expected an error for a file outside of the source tree")
+	}
+}
+
+// EOF
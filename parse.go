@@ -4,10 +4,8 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
-	//"io"
+	"io"
 	"os"
-	"regexp"
-	"strings"
 )
 
 const dbg_parse_line = false
@@ -49,8 +47,11 @@ type Parser struct {
 	table   map[string]ParseFunc
 	f       *os.File
 	scanner *bufio.Scanner
-	ctx     []string
+	ctx     []scope_ctx // stack of the private/public sections
 	tokens  []string
+	line    []byte   // logical line of the statement being parsed
+	pos     Pos      // position of the statement being parsed
+	notes   []string // comments waiting for their statement
 }
 
 func (p *Parser) Close() error {
@@ -71,34 +72,51 @@ func NewParser(fname string) (*Parser, error) {
 		return nil, err
 	}
 
-	scanner := bufio.NewScanner(bufio.NewReader(f))
+	p, err := newParser(fname, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	p.f = f
+	return p, nil
+}
+
+// newParser creates a parser reading requirements statements from r.
+// fname is only used to locate statements.
+func newParser(fname string, r io.Reader) (*Parser, error) {
+	scanner := bufio.NewScanner(bufio.NewReader(r))
 	if scanner == nil {
 		return nil, fmt.Errorf("cmt2yml: nil bufio.Scanner")
 	}
 
 	p := &Parser{
 		table:   g_dispatch,
-		f:       f,
 		scanner: scanner,
-		req:     &ReqFile{Filename: fname},
-		tokens:  nil,
-		ctx:     []string{tok_BEG_PUBLIC},
+		req: &ReqFile{
+			Filename: fname,
+			Infos:    make(map[Stmt]*StmtInfo),
+		},
+		tokens: nil,
+		ctx:    []scope_ctx{{scope: PublicScope}},
 	}
 	return p, nil
 }
 
 func (p *Parser) run() error {
 	var err error
-	bline := []byte{}
+	var bline []byte
+	var quote byte
 	my_printf := func(format string, args ...interface{}) (int, error) {
 		return 0, nil
 	}
 	if dbg_parse_line {
 		my_printf = func(format string, args ...interface{}) (int, error) {
-			return fmt.Printf(format, args...)
+			return fmt.Fprintf(g_log, format, args...)
 		}
 	}
+	lineno := 0
 	for p.scanner.Scan() {
+		lineno++
 		data := p.scanner.Bytes()
 		data = bytes.TrimSpace(data)
 		my_printf("-data: %v\n", string(data))
@@ -109,10 +127,24 @@ func (p *Parser) run() error {
 			continue
 		}
 
-		if data[0] == '#' {
+		// full-line and trailing comments.
+		// quote is carried over line continuations.
+		var icom int
+		icom, quote = find_comment(data, quote)
+		if icom >= 0 {
+			p.notes = append(p.notes, string(data[icom:]))
+			data = bytes.TrimSpace(data[:icom])
+			my_printf("#data: %v\n", string(data))
+		}
+		if len(data) == 0 {
 			continue
 		}
 
+		if bline == nil {
+			p.pos = Pos{File: p.req.Filename, Line: lineno}
+		}
+		p.pos.End = lineno
+
 		idx := len(data) - 1
 		if data[idx] == '\\' {
 			my_printf("!data: %v (line-continuation)\n", string(data))
@@ -125,28 +157,91 @@ func (p *Parser) run() error {
 		}
 
 		var tokens []string
-		tokens, err = parse_line(bline)
+		var comment string
+		tokens, comment, err = parse_line(bline)
 		if err != nil {
 			return err
 		}
+		if comment != "" {
+			p.notes = append(p.notes, comment)
+		}
 		p.tokens = tokens
+		p.line = bline
+		bline = nil
+		quote = 0
+		if len(tokens) == 0 {
+			continue
+		}
 
-		fct, ok := p.table[p.tokens[0]]
-		if !ok {
-			return fmt.Errorf("cmt2yml: unknown token [%v]", tokens[0])
+		p.dispatch()
+	}
+
+	// private section left open
+	p.end_scopes()
+
+	// comments not followed by any statement
+	p.drop_notes()
+
+	err = p.scanner.Err()
+	return err
+}
+
+// dispatch parses the current statement.
+// problems are recorded as diagnostics so the parsing can carry on with
+// the next statement.
+func (p *Parser) dispatch() {
+	fct, ok := p.table[p.tokens[0]]
+	if !ok {
+		p.errorf("unknown token [%v]", p.tokens[0])
+		p.drop_notes()
+		return
+	}
+
+	nstmts := len(p.req.Stmts)
+	defer func() {
+		if e := recover(); e != nil {
+			p.req.Stmts = p.req.Stmts[:nstmts]
+			p.errorf("malformed [%s] statement (%v)", p.tokens[0], e)
+			p.drop_notes()
 		}
-		err = fct(p)
-		if err != nil {
-			return err
+	}()
+
+	err := fct(p)
+	if err != nil {
+		p.req.Stmts = p.req.Stmts[:nstmts]
+		p.errorf("%v", err)
+		p.drop_notes()
+		return
+	}
+	for i, stmt := range p.req.Stmts[nstmts:] {
+		info := &StmtInfo{Pos: p.pos, Scope: p.scope()}
+		if i == 0 {
+			info.Comments = p.notes
+			p.notes = nil
 		}
-		bline = nil
+		p.req.Infos[stmt] = info
 	}
+}
 
-	return err
+// drop_notes moves the pending comments to the list of comments not
+// attached to any statement
+func (p *Parser) drop_notes() {
+	p.req.Comments = append(p.req.Comments, p.notes...)
+	p.notes = nil
+}
+
+// errorf records a diagnostic located at the statement being parsed
+func (p *Parser) errorf(format string, args ...interface{}) {
+	p.req.Diags = append(p.req.Diags, Diag{Pos: p.pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+// warnf records a warning located at the statement being parsed
+func (p *Parser) warnf(format string, args ...interface{}) {
+	p.req.Diags = append(p.req.Diags, Diag{Pos: p.pos, Msg: fmt.Sprintf(format, args...), Warn: true})
 }
 
 func parse_file(fname string) (*ReqFile, error) {
-	fmt.Printf("req=%q\n", fname)
+	logf("req=%q\n", fname)
 	p, err := NewParser(fname)
 	if err != nil {
 		return nil, err
@@ -155,116 +250,34 @@ func parse_file(fname string) (*ReqFile, error) {
 
 	err = p.run()
 	if err != nil {
-		fmt.Printf("req=%q [ERR]\n", fname)
+		logf("req=%q [ERR]\n", fname)
 		return nil, err
 	}
-	fmt.Printf("req=%q [done]\n", fname)
+	if p.req.IsPartial() {
+		logf("req=%q [partial]\n", fname)
+	} else {
+		logf("req=%q [done]\n", fname)
+	}
 	return p.req, err
 }
 
-func parse_line(data []byte) ([]string, error) {
+// parse_line splits a logical requirements line into its tokens and its
+// trailing comment
+func parse_line(data []byte) ([]string, string, error) {
 	var err error
-	line := []string{}
-
-	worder := bufio.NewScanner(bytes.NewBuffer(data))
-	worder.Split(bufio.ScanWords)
-	tokens := []string{}
-	for worder.Scan() {
-		tok := worder.Text()
-		if tok != "" {
-			tokens = append(tokens, worder.Text())
-		}
-	}
-
-	my_printf := func(format string, args ...interface{}) (int, error) {
-		return 0, nil
+	comment := ""
+	if idx, _ := find_comment(data, 0); idx >= 0 {
+		comment = string(bytes.TrimSpace(data[idx:]))
+		data = data[:idx]
 	}
+	tokens := newLexer(data).tokens()
 	if dbg_parse_line {
-		my_printf = func(format string, args ...interface{}) (int, error) {
-			return fmt.Printf(format, args...)
-		}
-	}
-
-	dq_re := regexp.MustCompile(`^(|\w*=)".*`)
-	sq_re := regexp.MustCompile(`^(|\w*=)'.*`)
-
-	my_printf("===============\n")
-	my_printf("@data: [%v]\n", string(data))
-	my_printf("tokens: %v\n", fmt_line(tokens))
-
-	in_dquote := false
-	in_squote := false
-	for i := 0; i < len(tokens); i++ {
-		tok := strings.Trim(tokens[i], " \t")
-		my_printf("tok[%d]=%q    (q=%v)\n", i, tok, in_squote || in_dquote)
-		if in_squote || in_dquote {
-			if len(line) > 0 {
-				ttok := tok
-				if strings.HasPrefix(ttok, `"`) || strings.HasPrefix(ttok, "'") {
-					ttok = ttok[1:]
-				}
-				if strings.HasSuffix(ttok, `"`) || strings.HasSuffix(ttok, "'") {
-					if !strings.HasSuffix(ttok, `\"`) {
-						ttok = ttok[:len(ttok)-1]
-					}
-				}
-				ttok = strings.Trim(ttok, " \t")
-				if len(ttok) > 0 {
-					line_val := line[len(line)-1]
-					line_sep := ""
-					if len(line_val) > 0 {
-						line_sep = " "
-					}
-					ttok = strings.Replace(ttok, `\"`, `"`, -1)
-					line[len(line)-1] += line_sep + ttok
-				}
-			} else {
-				panic("logic error")
-			}
-		} else {
-			ttok := tok
-			if strings.HasPrefix(ttok, `"`) || strings.HasPrefix(ttok, "'") {
-				ttok = ttok[1:]
-			}
-			if strings.HasSuffix(ttok, `"`) || strings.HasSuffix(ttok, "'") {
-				if !strings.HasSuffix(ttok, `\"`) {
-					ttok = ttok[:len(ttok)-1]
-				}
-			}
-			ttok = strings.Replace(ttok, `\"`, `"`, -1)
-			line = append(line, strings.Trim(ttok, " \t"))
-		}
-		if len(tok) == 1 && strings.HasPrefix(tok, "\"") {
-			in_dquote = !in_dquote
-			continue
-		}
-		if len(tok) == 1 && strings.HasPrefix(tok, "'") {
-			in_squote = !in_squote
-			continue
-		}
-		if in_dquote && strings.HasSuffix(tok, "\"") && !strings.HasSuffix(tok, `\""`) {
-			in_dquote = !in_dquote
-			my_printf("<-- dquote: %v -> %v\n", !in_dquote, in_dquote)
-		}
-		if in_squote && strings.HasSuffix(tok, "'") {
-			in_squote = !in_squote
-			my_printf("<-- squote: %v -> %v\n", !in_squote, in_squote)
-		}
-		if dq_re.MatchString(tok) {
-			if !strings.HasSuffix(tok, "\"") || strings.Count(tok, `"`) == 1 {
-				in_dquote = !in_dquote
-				my_printf("--> dquote: %v -> %v\n", !in_dquote, in_dquote)
-			}
-		}
-		if sq_re.MatchString(tok) {
-			if !strings.HasSuffix(tok, "'") || strings.Count(tok, `'`) == 1 {
-				in_squote = !in_squote
-				my_printf("--> squote: %v -> %v\n", !in_squote, in_squote)
-			}
-		}
+		logf("===============\n")
+		logf("@data: [%v]\n", string(data))
+		logf("tokens: %v\n", fmt_line(tokens))
+		logf("comment: %q\n", comment)
 	}
-
-	return line, err
+	return tokens, comment, err
 }
 
 // EOF
@@ -5,6 +5,8 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/hwaf/hwaf/hlib"
 )
 
 func TestParseLine(t *testing.T) {
@@ -27,7 +29,6 @@ func TestParseLine(t *testing.T) {
 				"x86_64", "x86_64-slc5",
 			},
 		},
-		// FIXME!!
 		{
 			fname: "testdata/pp_cppflags.txt",
 			expected: []string{
@@ -105,41 +106,31 @@ func TestParseLine(t *testing.T) {
 		{
 			fname: "testdata/unittest.txt",
 			expected: []string{
-				//FIXME:
-				//"apply_pattern", "Foo", "name=\"toto was there\"",
-				"apply_pattern", "Foo", `name="toto was there`,
+				"apply_pattern", "Foo", "name=\"toto was there\"",
 			},
 		},
 		{
 			fname: "testdata/unittest2.txt",
 			expected: []string{
-				//FIXME:
-				//"apply_pattern", "Foo", "name='toto was there'",
-				"apply_pattern", "Foo", "name='toto was there",
+				"apply_pattern", "Foo", "name='toto was there'",
 			},
 		},
 		{
 			fname: "testdata/unittest3.txt",
 			expected: []string{
-				//FIXME:
-				//"apply_pattern", "Foo", "name=\" toto was there\"",
-				"apply_pattern", "Foo", "name= toto was there",
+				"apply_pattern", "Foo", "name=\" toto was there\"",
 			},
 		},
 		{
 			fname: "testdata/unittest4.txt",
 			expected: []string{
-				//FIXME:
-				//"apply_pattern", "Foo", "name=' toto was there'",
-				"apply_pattern", "Foo", "name= toto was there",
+				"apply_pattern", "Foo", "name=' toto was there'",
 			},
 		},
 		{
 			fname: "testdata/declare_jobo.txt",
 			expected: []string{
-				//FIXME:
-				//"apply_pattern", "declare_joboptions", "files=\"-s=../share *.py\"",
-				"apply_pattern", "declare_joboptions", "files=\"-s=../share *.py",
+				"apply_pattern", "declare_joboptions", "files=\"-s=../share *.py\"",
 			},
 		},
 	} {
@@ -166,3 +157,332 @@ func TestParseLine(t *testing.T) {
 		}
 	}
 }
+
+func TestLexer(t *testing.T) {
+	for _, v := range []struct {
+		line     string
+		expected []string
+	}{
+		{
+			line:     `macro foo "" tag1 "bar"`,
+			expected: []string{"macro", "foo", "", "tag1", "bar"},
+		},
+		{
+			line:     `macro foo 'a "b" c'`,
+			expected: []string{"macro", "foo", `a "b" c`},
+		},
+		{
+			line:     `macro foo "a \"b\" c"`,
+			expected: []string{"macro", "foo", `a "b" c`},
+		},
+		{
+			line:     `macro foo "-DFOO=bar(1)"`,
+			expected: []string{"macro", "foo", "-DFOO=bar(1)"},
+		},
+		{
+			line:     `set FOO $(shell echo bar)/lib`,
+			expected: []string{"set", "FOO", "$(shell echo bar)/lib"},
+		},
+		{
+			line:     `set FOO ${a_$(b c)}/lib`,
+			expected: []string{"set", "FOO", "${a_$(b c)}/lib"},
+		},
+		{
+			line:     `apply_pattern Foo a="x y" b='z' c=`,
+			expected: []string{"apply_pattern", "Foo", `a="x y"`, "b='z'", "c="},
+		},
+		{
+			line:     `apply_pattern Foo a="x \"y\" z"`,
+			expected: []string{"apply_pattern", "Foo", `a="x \"y\" z"`},
+		},
+		{
+			line:     "\t  use   Foo\t Foo-* \t",
+			expected: []string{"use", "Foo", "Foo-*"},
+		},
+	} {
+		out, _, err := parse_line([]byte(v.line))
+		if err != nil {
+			t.Fatalf("line %q: %v", v.line, err)
+		}
+		if !reflect.DeepEqual(out, v.expected) {
+			t.Fatalf(
+				"line %q:\nexpected: %q\ngot:      %q\n",
+				v.line, v.expected, out,
+			)
+		}
+	}
+}
+
+func TestParsePos(t *testing.T) {
+	fname := "testdata/positions.txt"
+	req, err := parse_file(fname)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := []Pos{
+		{File: fname, Line: 1, End: 1},
+		{File: fname, Line: 4, End: 5},
+		{File: fname, Line: 7, End: 9},
+		{File: fname, Line: 11, End: 11},
+	}
+	if len(req.Stmts) != len(expected) {
+		t.Fatalf("expected %d statements. got %d", len(expected), len(req.Stmts))
+	}
+	for i, stmt := range req.Stmts {
+		pos := req.Pos(stmt)
+		if pos != expected[i] {
+			t.Fatalf("stmt #%d (%T): expected pos %#v. got %#v", i, stmt, expected[i], pos)
+		}
+	}
+
+}
+
+func TestParseRecover(t *testing.T) {
+	fname := "testdata/bad_stmts.txt"
+	req, err := parse_file(fname)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	diags := []string{
+		"testdata/bad_stmts.txt:3: unknown token [not_a_keyword]",
+		"testdata/bad_stmts.txt:5: malformed [macro] statement",
+	}
+	if len(req.Diags) != len(diags) {
+		t.Fatalf("expected %d diagnostics. got %d: %v", len(diags), len(req.Diags), req.Diags)
+	}
+	for i, diag := range req.Diags {
+		if !strings.HasPrefix(diag.Error(), diags[i]) {
+			t.Fatalf("diag #%d: expected %q. got %q", i, diags[i], diag.Error())
+		}
+	}
+
+	// package, use and apply_tag statements survived
+	if len(req.Stmts) != 3 {
+		t.Fatalf("expected 3 statements. got %d", len(req.Stmts))
+	}
+	if !req.IsPartial() {
+		t.Fatalf("expected a partial requirements file")
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	fname := "testdata/comments.txt"
+	req, err := parse_file(fname)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(req.Stmts) != 3 {
+		t.Fatalf("expected 3 statements. got %d", len(req.Stmts))
+	}
+	for i, expected := range [][]string{
+		{"# header comment"},
+		{"## the macro below works around a gcc bug", "# trailing"},
+		nil,
+	} {
+		comments := req.Infos[req.Stmts[i]].Comments
+		if !reflect.DeepEqual(comments, expected) {
+			t.Fatalf("stmt #%d: expected comments %q. got %q", i, expected, comments)
+		}
+	}
+	if !reflect.DeepEqual(req.Comments, []string{"# dangling comment"}) {
+		t.Fatalf("unexpected file comments: %q", req.Comments)
+	}
+	bar := req.Stmts[2].(*Macro)
+	if !reflect.DeepEqual(bar.Set[0].Value, []string{"a", "#", "b"}) {
+		t.Fatalf("unexpected macro value: %q", bar.Set[0].Value)
+	}
+
+	fname = "testdata/trailing_comments.txt"
+	req, err = parse_file(fname)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(req.Stmts) != 1 || len(req.Diags) != 0 {
+		t.Fatalf("expected 1 statement and no diagnostic. got %d (%v)", len(req.Stmts), req.Diags)
+	}
+	foo := req.Stmts[0].(*Macro)
+	if !reflect.DeepEqual(foo, &Macro{
+		Name: "foo",
+		Set: []hlib.KeyValue{
+			{Tag: "default", Value: []string{"bar"}},
+			{Tag: "x86_64", Value: []string{"baz#1"}},
+			{Tag: "i686", Value: []string{"#boo"}},
+		},
+	}) {
+		t.Fatalf("unexpected macro: %#v", foo)
+	}
+	expected := []string{"# explanation", "# a note about i686", "# done"}
+	if comments := req.Infos[foo].Comments; !reflect.DeepEqual(comments, expected) {
+		t.Fatalf("expected comments %q. got %q", expected, comments)
+	}
+}
+
+func TestParseKeywords(t *testing.T) {
+	fname := "testdata/keywords.txt"
+	req, err := parse_file(fname)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(req.Diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", req.Diags)
+	}
+	expected := []Stmt{
+		&UsePkg{Package: "Foo", Version: "Foo-*", Switches: []string{"-no_auto_imports"}},
+		&UsePkg{Package: "Bar", Version: "Bar-*", Path: "External", Switches: []string{"-no_auto_imports"}},
+		&SetPrepend{Name: "FOO", Set: []hlib.KeyValue{{Tag: "default", Value: []string{"bar"}}}},
+		&SetRemoveRegexp{Name: "FOO", Set: []hlib.KeyValue{{Tag: "default", Value: []string{"(ba)+r"}}}},
+		&MacroRemoveAll{Name: "foo", Set: []hlib.KeyValue{{Tag: "default", Value: []string{"-g"}}}},
+		&MacroRemoveRegexp{Name: "foo", Set: []hlib.KeyValue{{Tag: "default", Value: []string{"-O(1|2)"}}}},
+		&MacroRemoveAllRegexp{Name: "foo", Set: []hlib.KeyValue{{Tag: "default", Value: []string{"-O(1|2)"}}}},
+		&PathRemoveRegexp{Name: "PATH", Set: []hlib.KeyValue{{Tag: "default", Value: []string{"/afs/(.*)"}}}},
+		&CleanupScript{Script: "${FOOROOT}/cmt/cleanup"},
+		&StructureStrategy{Value: "without_version_directory"},
+		&Document{Name: "doxygen", Args: []string{"MyDoc", "-group=doc", "-s=../doc", "main.dox"}},
+		&Branches{Name: []string{"run", "doc"}},
+		&SetupScript{Script: "${FOOROOT}/cmt/setup_foo"},
+		&SetupStrategy{Values: []string{"no_root", "no_config"}},
+		&BuildStrategy{Values: []string{"with_installarea"}},
+		&Language{Name: "fortran", Args: []string{"-suffix=f", "-linker=$(flink)"}},
+	}
+	if len(req.Stmts) != len(expected) {
+		t.Fatalf("expected %d statements. got %d", len(expected), len(req.Stmts))
+	}
+	for i, stmt := range req.Stmts {
+		if !reflect.DeepEqual(stmt, expected[i]) {
+			t.Fatalf("stmt #%d:\nexpected: %#v\ngot:      %#v", i, expected[i], stmt)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	fname := "testdata/scopes.txt"
+	req, err := parse_file(fname)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	diags := []string{
+		"testdata/scopes.txt:3: redundant public section (statements are public by default)",
+		"testdata/scopes.txt:5: unbalanced end_private (no private section is open)",
+		"testdata/scopes.txt:8: redundant private section (already private since line 6)",
+		"testdata/scopes.txt:10: end_public closes the private section opened at line 8",
+		"testdata/scopes.txt:13: private section is never closed (private up to the end of the file)",
+	}
+	if len(req.Diags) != len(diags) {
+		t.Fatalf("expected %d diagnostics. got %d: %v", len(diags), len(req.Diags), req.Diags)
+	}
+	for i, diag := range req.Diags {
+		if diag.Error() != diags[i] {
+			t.Fatalf("diag #%d: expected %q. got %q", i, diags[i], diag.Error())
+		}
+		if !diag.Warn {
+			t.Fatalf("diag #%d: expected a warning", i)
+		}
+	}
+
+	scopes := map[string]Scope{}
+	for _, stmt := range req.Stmts {
+		switch x := stmt.(type) {
+		case *Macro:
+			scopes[x.Name] = req.Scope(x)
+		case *UsePkg:
+			scopes[x.Package] = req.Scope(x)
+		}
+	}
+	expected := map[string]Scope{
+		"a":   PrivateScope,
+		"b":   PrivateScope,
+		"c":   PublicScope,
+		"Foo": PrivateScope,
+	}
+	if !reflect.DeepEqual(scopes, expected) {
+		t.Fatalf("expected scopes %v. got %v", expected, scopes)
+	}
+}
+
+func TestParseCmtPathPattern(t *testing.T) {
+	req, err := parse_file("testdata/cmtpath_pattern.txt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(req.Stmts) != 3 {
+		t.Fatalf("expected 3 statements. got %d: %v", len(req.Stmts), req.Stmts)
+	}
+
+	// the command starts right after the keyword
+	cmd := req.Stmts[1].(*CmtPathPattern).Cmd
+	expected := []string{"macro_append", "includes", "-I<path>/include"}
+	if !reflect.DeepEqual(cmd, expected) {
+		t.Fatalf("cmtpath_pattern: expected %q. got %q", expected, cmd)
+	}
+	cmd = req.Stmts[2].(*CmtPathPatternReverse).Cmd
+	expected = []string{"path_prepend", "PATH", "<path>/bin"}
+	if !reflect.DeepEqual(cmd, expected) {
+		t.Fatalf("cmtpath_pattern_reverse: expected %q. got %q", expected, cmd)
+	}
+}
+
+func TestParseScopesToggle(t *testing.T) {
+	req, err := parse_file("testdata/scopes_toggle.txt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// only the private section left open at the end of the file is
+	// reported
+	diags := []string{}
+	for _, diag := range req.Diags {
+		diags = append(diags, diag.Error())
+	}
+	if expected := []string{
+		"testdata/scopes_toggle.txt:8: private section is never closed (private up to the end of the file)",
+	}; !reflect.DeepEqual(diags, expected) || req.IsPartial() {
+		t.Fatalf("expected warnings %v. got %v", expected, req.Diags)
+	}
+
+	scopes := map[string]Scope{}
+	for _, stmt := range req.Stmts {
+		if x, ok := stmt.(*Macro); ok {
+			scopes[x.Name] = req.Scope(x)
+		}
+	}
+	expected := map[string]Scope{
+		"a": PublicScope,
+		"b": PrivateScope,
+		"c": PublicScope,
+		"d": PrivateScope,
+	}
+	if !reflect.DeepEqual(scopes, expected) {
+		t.Fatalf("expected scopes %v. got %v", expected, scopes)
+	}
+}
+
+func TestParseUseExtraArgs(t *testing.T) {
+	req, err := parse_file("testdata/use_extra.txt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(req.Stmts) != 2 {
+		t.Fatalf("expected 2 statements. got %d: %v", len(req.Stmts), req.Stmts)
+	}
+	use, ok := req.Stmts[1].(*UsePkg)
+	if !ok {
+		t.Fatalf("expected a use statement. got %T", req.Stmts[1])
+	}
+	expected := UsePkg{
+		Package:  "AthenaKernel",
+		Version:  "AthenaKernel-*",
+		Path:     "Control",
+		Switches: []string{"-no_auto_imports"},
+	}
+	if !reflect.DeepEqual(*use, expected) {
+		t.Fatalf("expected %#v. got %#v", expected, *use)
+	}
+
+	diags := []string{
+		"testdata/use_extra.txt:3: too many arguments to use statement: [extra junk] (ignored)",
+	}
+	if len(req.Diags) != len(diags) || req.Diags[0].Error() != diags[0] || !req.Diags[0].Warn {
+		t.Fatalf("expected warnings %q. got %v", diags, req.Diags)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// g_pattern_max_depth bounds the nesting of pattern instantiations
+const g_pattern_max_depth = 16
+
+// matches the <name> templates of a pattern definition
+var g_pattern_tmpl_re = regexp.MustCompile(`<(\w+)>`)
+
+// PatternDef is a pattern definition, together with the package which
+// defines it
+type PatternDef struct {
+	Pattern *Pattern
+	Package string
+	Pos     Pos
+}
+
+// PatternRegistry holds the pattern definitions of a set of packages,
+// e.g. the policy packages of a release.
+type PatternRegistry struct {
+	defs map[string]*PatternDef
+}
+
+// g_patterns is the registry of the patterns defined by every parsed
+// requirements file
+var g_patterns = NewPatternRegistry()
+
+func NewPatternRegistry() *PatternRegistry {
+	return &PatternRegistry{defs: make(map[string]*PatternDef)}
+}
+
+// Add registers the patterns defined by a requirements file.
+// the first definition of a pattern wins: conflicting redefinitions are
+// reported as warnings of req.
+func (reg *PatternRegistry) Add(req *ReqFile) {
+	pkg := req.Package.Name
+	if pkg == "" {
+		pkg = filepath.Base(pkg_layout(req.Filename).Name)
+	}
+	for _, stmt := range req.Stmts {
+		x, ok := stmt.(*Pattern)
+		if !ok {
+			continue
+		}
+		if def, dup := reg.defs[x.Name]; dup {
+			if def.Pattern.Def != x.Def {
+				req.warn(x, fmt.Errorf(
+					"pattern [%s] already defined by [%s] at %v (definition ignored)",
+					x.Name, def.Package, def.Pos,
+				))
+			}
+			continue
+		}
+		reg.defs[x.Name] = &PatternDef{Pattern: x, Package: pkg, Pos: req.Pos(x)}
+	}
+}
+
+// Lookup returns the definition of the named pattern
+func (reg *PatternRegistry) Lookup(name string) (*PatternDef, bool) {
+	def, ok := reg.defs[name]
+	return def, ok
+}
+
+// Len returns the number of registered patterns
+func (reg *PatternRegistry) Len() int {
+	return len(reg.defs)
+}
+
+// instantiate_pattern substitutes the <name> templates of a pattern
+// definition with their values.
+// as for CMT, templates with no value are replaced by an empty string.
+func instantiate_pattern(def string, args map[string]string) string {
+	return g_pattern_tmpl_re.ReplaceAllStringFunc(def, func(tmpl string) string {
+		return args[tmpl[1:len(tmpl)-1]]
+	})
+}
+
+// pattern_args parses the name=value arguments of an apply_pattern
+// statement.
+// values are kept as written, except for their enclosing quotes, and may
+// be empty.
+func pattern_args(args []string) (map[string]string, error) {
+	o := make(map[string]string, len(args))
+	for _, arg := range args {
+		idx := strings.Index(arg, "=")
+		if idx < 0 {
+			return o, fmt.Errorf("could not find '=' in argument [%s]", arg)
+		}
+		if idx == 0 {
+			return o, fmt.Errorf("missing name in argument [%s]", arg)
+		}
+		k, v := arg[:idx], arg[idx+1:]
+		if len(v) > 1 && is_quote(v[0]) && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		o[k] = v
+	}
+	return o, nil
+}
+
+// split_pattern splits a pattern body into its statements, separated by
+// ';' outside of quoted strings
+func split_pattern(def string) []string {
+	stmts := []string{}
+	beg := 0
+	var quote byte
+	for i := 0; i < len(def); i++ {
+		c := def[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case is_quote(c):
+			quote = c
+		case c == ';':
+			stmts = append(stmts, def[beg:i])
+			beg = i + 1
+		}
+	}
+	stmts = append(stmts, def[beg:])
+
+	out := make([]string, 0, len(stmts))
+	for _, stmt := range stmts {
+		stmt = strings.TrimSpace(stmt)
+		if stmt != "" {
+			out = append(out, stmt)
+		}
+	}
+	return out
+}
+
+// parse_pattern parses the statements of an instantiated pattern
+func parse_pattern(fname, def string) (*ReqFile, error) {
+	src := new(bytes.Buffer)
+	for _, stmt := range split_pattern(def) {
+		fmt.Fprintf(src, "%s\n", stmt)
+	}
+	p, err := newParser(fname, src)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	err = p.run()
+	if err != nil {
+		return nil, err
+	}
+	return p.req, err
+}
+
+// pattern_builtins returns the values of the templates CMT defines for
+// every pattern of a package
+func (r *Renderer) pattern_builtins() map[string]string {
+	pkg := r.req.Package.Name
+	if pkg == "" {
+		pkg = filepath.Base(r.pkg.Package.Name)
+	}
+	return map[string]string{
+		"package": pkg,
+		"PACKAGE": strings.ToUpper(pkg),
+		"version": req_version(r.req),
+	}
+}
+
+// lookup_pattern returns the definition of the named pattern: the one of
+// the package itself if any, the one of the pattern registry otherwise.
+func (r *Renderer) lookup_pattern(name string) (*PatternDef, bool) {
+	for _, stmt := range r.req.Stmts {
+		if x, ok := stmt.(*Pattern); ok && x.Name == name {
+			return &PatternDef{Pattern: x, Package: r.req.Package.Name, Pos: r.req.Pos(x)}, true
+		}
+	}
+	return g_patterns.Lookup(name)
+}
+
+// is_local_pattern returns whether def is defined by the package itself
+func (r *Renderer) is_local_pattern(def *PatternDef) bool {
+	return def.Pos.File == r.req.Filename
+}
+
+// note_pattern documents a converted or expanded apply_pattern statement
+// with the origin of the pattern definition
+func (r *Renderer) note_pattern(x *ApplyPattern, what string) {
+	def, ok := r.lookup_pattern(x.Name)
+	if !ok || r.is_local_pattern(def) {
+		return
+	}
+	r.note(x, fmt.Sprintf(
+		"apply_pattern %s: %s (pattern defined by [%s] at %v: %s)",
+		x.Name, what, def.Package, def.Pos, def.Pattern.Def,
+	))
+}
+
+// expand_patterns replaces the apply_pattern statements which have no
+// profile converter by the statements of the applied pattern, defined
+// either by the package itself or by the pattern registry.
+// patterns with no known definition are kept as-is.
+func (r *Renderer) expand_patterns(stmts []Stmt, depth int) []Stmt {
+	out := make([]Stmt, 0, len(stmts))
+	for _, stmt := range stmts {
+		x, ok := stmt.(*ApplyPattern)
+		if !ok {
+			out = append(out, stmt)
+			continue
+		}
+		if _, ok := g_profile.cnvs[x.Name]; ok {
+			out = append(out, stmt)
+			continue
+		}
+		def, ok := r.lookup_pattern(x.Name)
+		if !ok {
+			out = append(out, stmt)
+			continue
+		}
+		if depth >= g_pattern_max_depth {
+			r.req.diag(x, fmt.Errorf("pattern [%s]: too many nested patterns", x.Name))
+			continue
+		}
+
+		args, err := pattern_args(x.Args)
+		if err != nil {
+			r.req.diag(x, fmt.Errorf("pattern [%s]: %v", x.Name, err))
+			continue
+		}
+		for k, v := range r.pattern_builtins() {
+			if _, dup := args[k]; !dup {
+				args[k] = v
+			}
+		}
+
+		pos := r.req.Pos(x)
+		req, err := parse_pattern(pos.File, instantiate_pattern(def.Pattern.Def, args))
+		if err != nil {
+			r.req.diag(x, fmt.Errorf("pattern [%s]: %v", x.Name, err))
+			continue
+		}
+		for _, diag := range req.Diags {
+			err := fmt.Errorf("pattern [%s]: %s", x.Name, diag.Msg)
+			if diag.Warn {
+				r.req.warn(x, err)
+			} else {
+				r.req.diag(x, err)
+			}
+		}
+
+		// expanded statements are located at the apply_pattern statement
+		// and inherit its comments.
+		// they are private if they are declared so by the pattern body, or
+		// if the pattern is applied from a private section.
+		for i, sub := range req.Stmts {
+			info := &StmtInfo{Pos: pos, Scope: req.Scope(sub)}
+			if r.req.Scope(x) == PrivateScope {
+				info.Scope = PrivateScope
+			}
+			if i == 0 {
+				if xinfo, ok := r.req.Infos[x]; ok {
+					info.Comments = xinfo.Comments
+				}
+			}
+			r.req.Infos[sub] = info
+		}
+		r.note_pattern(x, "expanded")
+		out = append(out, r.expand_patterns(req.Stmts, depth+1)...)
+	}
+	return out
+}
+
+// EOF
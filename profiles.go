@@ -9,6 +9,23 @@ type cnvfct_t func(wscript *hlib.Wscript_t, stmt Stmt) error
 type Profile struct {
 	features map[string][]string
 	cnvs     map[string]cnvfct_t
+	tags     TagTable // CMT to hwaf tag translations
+	vars     VarTable // CMT to hwaf built-in variable rewrites
+}
+
+// g_lang_tools maps CMT languages to the waf tools handling them
+var g_lang_tools = map[string]string{
+	"c":       "compiler_c",
+	"c++":     "compiler_cxx",
+	"cxx":     "compiler_cxx",
+	"cpp":     "compiler_cxx",
+	"fortran": "compiler_fc",
+	"f77":     "compiler_fc",
+	"f90":     "compiler_fc",
+	"java":    "java",
+	"python":  "python",
+	"lex":     "flex",
+	"yacc":    "bison",
 }
 
 var (
@@ -23,6 +40,12 @@ func init() {
 			"application": []string{"tdaq_application"},
 			"library":     []string{"tdaq_library"},
 		},
+		tags: new_tag_table(g_lcg_tags, TagTable{
+			// embedded platforms of the RCE boards
+			"ppc-rtems-rce405": "",
+			"rtems":            "",
+		}),
+		vars: new_var_table(),
 		cnvs: map[string]cnvfct_t{
 			// TDAQCExternal
 			"declare_lcg_mapping":  cnv_tdaq_declare_lcg_mapping,
@@ -62,6 +85,14 @@ func init() {
 			"application": []string{"atlas_application"},
 			"library":     []string{"atlas_library"},
 		},
+		tags: new_tag_table(g_lcg_tags, TagTable{
+			// MacOSX builds
+			"mac105": "darwin&mac105",
+			"mac106": "darwin&mac106",
+			"gcc40":  "gcc&gcc40",
+			"gcc42":  "gcc&gcc42",
+		}),
+		vars: new_var_table(),
 		cnvs: map[string]cnvfct_t{
 			// DetCommonPolicy
 			"detcommon_shared_library":         cnv_detcommon_shared_library,
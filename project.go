@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// g_project_dispatch is the dispatch table of cmt/project.cmt files
+var g_project_dispatch = map[string]ParseFunc{
+	"project":                 parseProjectName,
+	"use":                     parseProjectUse,
+	"container":               parseContainer,
+	"author":                  parseAuthor,
+	"build_strategy":          parseBuildStrategy,
+	"setup_strategy":          parseSetupStrategy,
+	"structure_strategy":      parseStructureStrategy,
+	"cmtpath_pattern":         parseCmtPathPattern,
+	"cmtpath_pattern_reverse": parseCmtPathPatternReverse,
+}
+
+// ProjectName models:
+//
+//	project <name>
+type ProjectName struct {
+	Name string
+}
+
+func (s *ProjectName) ToYaml(w io.Writer) error {
+	return nil
+}
+
+func parseProjectName(p *Parser) error {
+	var err error
+	p.req.Stmts = append(p.req.Stmts, &ProjectName{Name: p.tokens[1]})
+	return err
+}
+
+// ProjectUse models:
+//
+//	use <project> [<release>] [<path>]
+type ProjectUse struct {
+	Name    string
+	Release string
+	Path    string
+}
+
+func (s *ProjectUse) ToYaml(w io.Writer) error {
+	return nil
+}
+
+func parseProjectUse(p *Parser) error {
+	var err error
+	tokens := p.tokens
+	use := &ProjectUse{Name: tokens[1]}
+	if len(tokens) > 2 {
+		use.Release = tokens[2]
+	}
+	if len(tokens) > 3 {
+		use.Path = tokens[3]
+	}
+	if len(tokens) > 4 {
+		return fmt.Errorf("too many arguments to use statement: %v", tokens[4:])
+	}
+	p.req.Stmts = append(p.req.Stmts, use)
+	return err
+}
+
+// Container models:
+//
+//	container <package> [<version>] [<offset>]
+type Container struct {
+	Name    string
+	Version string
+	Path    string
+}
+
+func (s *Container) ToYaml(w io.Writer) error {
+	return nil
+}
+
+func parseContainer(p *Parser) error {
+	var err error
+	tokens := p.tokens
+	vv := &Container{Name: tokens[1]}
+	if len(tokens) > 2 {
+		vv.Version = tokens[2]
+	}
+	if len(tokens) > 3 {
+		vv.Path = tokens[3]
+	}
+	p.req.Stmts = append(p.req.Stmts, vv)
+	return err
+}
+
+// Project is a CMT project, as described by its cmt/project.cmt file
+type Project struct {
+	req   *ReqFile
+	notes []comment_anchor // statements with no hproject.yml field
+
+	Name string
+	Uses []*ProjectUse
+}
+
+// parse_project parses a cmt/project.cmt file
+func parse_project(fname string) (*Project, error) {
+	logf("project=%q\n", fname)
+	p, err := NewParser(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+	p.table = g_project_dispatch
+
+	err = p.run()
+	if err != nil {
+		logf("project=%q [ERR]\n", fname)
+		return nil, err
+	}
+
+	proj := NewProject(p.req)
+	if p.req.IsPartial() {
+		logf("project=%q [partial]\n", fname)
+	} else {
+		logf("project=%q [done]\n", fname)
+	}
+	return proj, err
+}
+
+// NewProject builds a project from the statements of its project.cmt file.
+// statements hwaf has no project field for are kept as comments, those it
+// has no equivalent for are recorded as diagnostics.
+func NewProject(req *ReqFile) *Project {
+	proj := &Project{req: req}
+	for _, stmt := range req.Stmts {
+		switch x := stmt.(type) {
+		case *ProjectName:
+			proj.Name = x.Name
+		case *ProjectUse:
+			proj.Uses = append(proj.Uses, x)
+		case *Author:
+			proj.note(x, "author "+x.Name)
+		case *Container:
+			proj.note(x, strings.TrimSpace(strings.Join([]string{"container", x.Name, x.Version, x.Path}, " ")))
+		case *BuildStrategy:
+			proj.note(x, "build_strategy "+strings.Join(x.Values, " "))
+		case *SetupStrategy:
+			proj.note(x, "setup_strategy "+strings.Join(x.Values, " "))
+		case *StructureStrategy:
+			proj.note(x, "structure_strategy "+x.Value)
+		case *CmtPathPattern:
+			req.diag(x, fmt.Errorf("no hwaf equivalent for [cmtpath_pattern %s] (statement dropped)", strings.Join(x.Cmd, " ")))
+		case *CmtPathPatternReverse:
+			req.diag(x, fmt.Errorf("no hwaf equivalent for [cmtpath_pattern_reverse %s] (statement dropped)", strings.Join(x.Cmd, " ")))
+		default:
+			req.diag(x, fmt.Errorf("unhandled statement [%v] (type=%T)", x, x))
+		}
+	}
+	if proj.Name == "" {
+		projdir, err := filepath.Abs(filepath.Dir(filepath.Dir(req.Filename)))
+		if err != nil {
+			projdir = filepath.Dir(filepath.Dir(req.Filename))
+		}
+		proj.Name = filepath.Base(projdir)
+		req.warn(nil, fmt.Errorf("no project statement (using [%s])", proj.Name))
+	}
+	return proj
+}
+
+// note keeps a statement with no hproject.yml field as a comment
+func (proj *Project) note(stmt Stmt, text string) {
+	proj.notes = append(proj.notes, comment_anchor{
+		pos:      proj.req.Pos(stmt),
+		comments: []string{"# " + text},
+	})
+}
+
+// Encode writes the hwaf project configuration: its name and the projects
+// it uses
+func (proj *Project) Encode(w io.Writer) error {
+	var err error
+	const indent = "    "
+	fmt.Fprintf(w, "project: {\n")
+	fmt.Fprintf(w, "  name: %q,\n", proj.Name)
+	if len(proj.Uses) > 0 {
+		fmt.Fprintf(w, "  deps: [\n")
+		for _, use := range proj.Uses {
+			fmt.Fprintf(w, "%s{name: %q", indent, use.Name)
+			if use.Release != "" {
+				fmt.Fprintf(w, ", version: %q", use.Release)
+			}
+			if use.Path != "" {
+				fmt.Fprintf(w, ", path: %q", use.Path)
+			}
+			fmt.Fprintf(w, "},\n")
+		}
+		fmt.Fprintf(w, "  ],\n")
+	}
+	_, err = fmt.Fprintf(w, "}\n")
+	return err
+}
+
+// render_project writes the hproject.yml file of a project, next to its
+// cmt directory
+func render_project(proj *Project) error {
+	var err error
+	projdir := filepath.Dir(filepath.Dir(proj.req.Filename))
+	fname := filepath.Join(projdir, "hproject.yml")
+	if is_user_file(fname) {
+		// user generated file.
+		// keep it.
+		logf("**warning** file [%s] already present\n", fname)
+		return skip_script(projdir, fname, "user-written file")
+	}
+
+	buf := new(bytes.Buffer)
+	_, err = fmt.Fprintf(buf, "## automatically generated by cmt2yml\n## do NOT edit\n\n")
+	if err != nil {
+		return err
+	}
+	err = write_diags(buf, proj.req)
+	if err != nil {
+		return err
+	}
+	err = proj.Encode(buf)
+	if err != nil {
+		return err
+	}
+
+	anchors := []comment_anchor{}
+	for _, stmt := range proj.req.Stmts {
+		info, ok := proj.req.Infos[stmt]
+		if !ok || len(info.Comments) == 0 {
+			continue
+		}
+		anchors = append(anchors, comment_anchor{
+			key:      stmt_key(stmt),
+			pos:      info.Pos,
+			comments: info.Comments,
+		})
+	}
+	anchors = append(anchors, proj.notes...)
+	if len(proj.req.Comments) > 0 {
+		anchors = append(anchors, comment_anchor{
+			pos:      Pos{File: proj.req.Filename},
+			comments: proj.req.Comments,
+		})
+	}
+
+	return write_script(projdir, fname, "project file", insert_comments(buf.Bytes(), anchors))
+}
+
+// EOF
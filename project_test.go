@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProject(t *testing.T) {
+	proj, err := parse_project("testdata/project/cmt/project.cmt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if proj.Name != "AtlasCore" {
+		t.Fatalf("expected project AtlasCore. got %q", proj.Name)
+	}
+	if len(proj.Uses) != 2 {
+		t.Fatalf("expected 2 projects in use. got %d", len(proj.Uses))
+	}
+	if use := proj.Uses[1]; use.Name != "GAUDI" || use.Release != "GAUDI_v22r1p9" || use.Path != "/afs/cern.ch/atlas/offline" {
+		t.Fatalf("unexpected project use: %+v", use)
+	}
+
+	// statements with no hproject.yml field are kept as comments
+	notes := []string{}
+	for _, note := range proj.notes {
+		notes = append(notes, fmt.Sprintf("%v: %s", note.pos, strings.Join(note.comments, "|")))
+	}
+	expected := []string{
+		"testdata/project/cmt/project.cmt:7: # container AtlasCoreRelease",
+		"testdata/project/cmt/project.cmt:9: # build_strategy with_installarea",
+		"testdata/project/cmt/project.cmt:10: # setup_strategy root",
+		"testdata/project/cmt/project.cmt:11: # structure_strategy without_version_directory",
+	}
+	if !reflect.DeepEqual(notes, expected) {
+		t.Fatalf("expected notes:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(notes, "\n"))
+	}
+
+	errs := proj.req.Errors()
+	if len(errs) != 1 || errs[0].Error() != `testdata/project/cmt/project.cmt:13: no hwaf equivalent for [cmtpath_pattern macro foo bar] (statement dropped)` {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := new(bytes.Buffer)
+	err = proj.Encode(buf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	yml := `project: {
+  name: "AtlasCore",
+  deps: [
+    {name: "AtlasConditions", version: "AtlasConditions-17.2.0"},
+    {name: "GAUDI", version: "GAUDI_v22r1p9", path: "/afs/cern.ch/atlas/offline"},
+  ],
+}
+`
+	if buf.String() != yml {
+		t.Fatalf("expected:\n%s\ngot:\n%s", yml, buf.String())
+	}
+}
+
+func TestProjectNoName(t *testing.T) {
+	p, err := newParser("testdata/project/cmt/project.cmt", bytes.NewBufferString("use LCGCMT LCGCMT_61b\n"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p.table = g_project_dispatch
+	err = p.run()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	proj := NewProject(p.req)
+	if proj.Name != "project" {
+		t.Fatalf("expected the project to be named after its directory. got %q", proj.Name)
+	}
+	if proj.req.IsPartial() || len(proj.req.Diags) != 1 {
+		t.Fatalf("expected 1 warning. got %v", proj.req.Diags)
+	}
+}
+
+// EOF
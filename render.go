@@ -1,8 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
-	"os"
+	"io"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -13,24 +14,25 @@ import (
 )
 
 type Renderer struct {
-	req     *ReqFile
-	wscript bool
-	w       *os.File
-	pkg     hlib.Wscript_t
+	req      *ReqFile
+	why      []py_reason // why a hscript.py is needed
+	w        io.Writer
+	pkg      hlib.Wscript_t
+	comments []comment_anchor // requirements comments to carry over
 }
 
 func NewRenderer(req *ReqFile) (*Renderer, error) {
 	var err error
 	var r *Renderer
 
-	r = &Renderer{req: req, wscript: false}
+	r = &Renderer{req: req}
 	return r, err
 }
 
 func (r *Renderer) Close() error {
 	var err error
-	if r.w != nil {
-		err = r.w.Close()
+	if w, ok := r.w.(io.Closer); ok {
+		err = w.Close()
 	}
 	return err
 }
@@ -51,21 +53,25 @@ func (r *Renderer) Render() error {
 func (r *Renderer) analyze() error {
 	var err error
 
-	basedir := filepath.Dir(filepath.Dir(r.req.Filename))
+	layout := pkg_layout(r.req.Filename)
 
 	r.pkg = hlib.Wscript_t{
-		Package:   hlib.Package_t{Name: basedir},
+		Package:   hlib.Package_t{Name: layout.Name},
 		Configure: hlib.Configure_t{Env: make(hlib.Env_t)},
 		Build:     hlib.Build_t{Env: make(hlib.Env_t)},
 	}
 	wscript := &r.pkg
 
+	stmts := r.expand_patterns(r.req.Stmts, 0)
+	stmts = r.translate_tags(stmts)
+	stmts = r.rewrite_vars(stmts)
+
 	// targets
 	apps := make(map[string]*Application)
 	libs := make(map[string]*Library)
 
 	// first pass: discover targets
-	for _, stmt := range r.req.Stmts {
+	for _, stmt := range stmts {
 		switch stmt.(type) {
 		case *Application:
 			x := stmt.(*Application)
@@ -99,7 +105,7 @@ func (r *Renderer) analyze() error {
 	//fmt.Printf("+++ tgt_names: %v\n", tgt_names)
 
 	// second pass: collect macros
-	for _, stmt := range r.req.Stmts {
+	for _, stmt := range stmts {
 		switch x := stmt.(type) {
 		default:
 			continue
@@ -129,12 +135,9 @@ func (r *Renderer) analyze() error {
 		}
 	}
 
-	// models private/public, end_private/end_public
-	ctx_visible := []bool{true}
-
 	// 3rd pass: collect libraries and apps
 	// this is to make sure the profile-converters get them already populated
-	for _, stmt := range r.req.Stmts {
+	for _, stmt := range stmts {
 		wbld := &wscript.Build
 		switch x := stmt.(type) {
 		case *Library:
@@ -179,21 +182,26 @@ func (r *Renderer) analyze() error {
 	}
 
 	// 4th pass to collect
-	for _, stmt := range r.req.Stmts {
+	for _, stmt := range stmts {
+		r.anchor(stmt)
+		if v := stmt_value(stmt); v != nil {
+			for _, err := range check_value_tags(v) {
+				r.req.warn(stmt, err)
+			}
+		}
 		wpkg := &wscript.Package
 		wbld := &wscript.Build
 		wcfg := &wscript.Configure
+		// private macros, sets and paths do not leak to the clients of
+		// the package: they only go to its build settings
+		scoped := &wcfg.Stmts
+		if r.req.Scope(stmt) == PrivateScope {
+			scoped = &wbld.Stmts
+		}
 		switch x := stmt.(type) {
 
-		case *BeginPublic:
-			ctx_visible = append(ctx_visible, true)
-		case *EndPublic:
-			ctx_visible = ctx_visible[:len(ctx_visible)-1]
-
-		case *BeginPrivate:
-			ctx_visible = append(ctx_visible, false)
-		case *EndPrivate:
-			ctx_visible = ctx_visible[:len(ctx_visible)-1]
+		case *BeginPublic, *EndPublic, *BeginPrivate, *EndPrivate:
+			// the scope of each statement was recorded by the parser
 
 		case *Author:
 			wpkg.Authors = append(wpkg.Authors, hlib.Author(x.Name))
@@ -206,7 +214,7 @@ func (r *Renderer) analyze() error {
 
 		case *UsePkg:
 			deptype := hlib.PrivateDep
-			if ctx_visible[len(ctx_visible)-1] {
+			if r.req.Scope(x) == PublicScope {
 				deptype = hlib.PublicDep
 			}
 			if str_is_in_slice(x.Switches, "-no_auto_imports") {
@@ -231,7 +239,7 @@ func (r *Renderer) analyze() error {
 				continue
 			}
 			val := hlib.Value(*x)
-			wcfg.Stmts = append(wcfg.Stmts, &hlib.MacroStmt{Value: val})
+			*scoped = append(*scoped, &hlib.MacroStmt{Value: val})
 
 		case *MacroAppend:
 			if _, ok := macros[x.Name]; ok {
@@ -239,7 +247,7 @@ func (r *Renderer) analyze() error {
 				continue
 			}
 			val := hlib.Value(*x)
-			wcfg.Stmts = append(wcfg.Stmts, &hlib.MacroAppendStmt{Value: val})
+			*scoped = append(*scoped, &hlib.MacroAppendStmt{Value: val})
 
 		case *MacroPrepend:
 			if _, ok := macros[x.Name]; ok {
@@ -247,7 +255,7 @@ func (r *Renderer) analyze() error {
 				continue
 			}
 			val := hlib.Value(*x)
-			wcfg.Stmts = append(wcfg.Stmts, &hlib.MacroPrependStmt{Value: val})
+			*scoped = append(*scoped, &hlib.MacroPrependStmt{Value: val})
 
 		case *MacroRemove:
 			if _, ok := macros[x.Name]; ok {
@@ -255,33 +263,33 @@ func (r *Renderer) analyze() error {
 				continue
 			}
 			val := hlib.Value(*x)
-			wcfg.Stmts = append(wcfg.Stmts, &hlib.MacroRemoveStmt{Value: val})
+			*scoped = append(*scoped, &hlib.MacroRemoveStmt{Value: val})
 
 		case *Path:
 			val := hlib.Value(*x)
-			wcfg.Stmts = append(wcfg.Stmts, &hlib.PathStmt{Value: val})
+			*scoped = append(*scoped, &hlib.PathStmt{Value: val})
 
 		case *PathAppend:
 			val := hlib.Value(*x)
-			wcfg.Stmts = append(wcfg.Stmts, &hlib.PathAppendStmt{Value: val})
+			*scoped = append(*scoped, &hlib.PathAppendStmt{Value: val})
 
 		case *PathPrepend:
 			val := hlib.Value(*x)
-			wcfg.Stmts = append(wcfg.Stmts, &hlib.PathPrependStmt{Value: val})
+			*scoped = append(*scoped, &hlib.PathPrependStmt{Value: val})
 
 		case *PathRemove:
 			val := hlib.Value(*x)
-			wcfg.Stmts = append(wcfg.Stmts, &hlib.PathRemoveStmt{Value: val})
+			*scoped = append(*scoped, &hlib.PathRemoveStmt{Value: val})
 
 		case *Pattern:
 			wcfg.Stmts = append(wcfg.Stmts, (*hlib.PatternStmt)(x))
 
 		case *ApplyPattern:
 			if cnv, ok := g_profile.cnvs[x.Name]; ok {
-				err = cnv(wscript, x)
-				if err != nil {
-					return err
+				if err := r.convert(cnv, x); err != nil {
+					r.req.diag(x, err)
 				}
+				r.note_pattern(x, "converted by the profile")
 			} else {
 				wbld.Stmts = append(wbld.Stmts, (*hlib.ApplyPatternStmt)(x))
 			}
@@ -301,15 +309,71 @@ func (r *Renderer) analyze() error {
 
 		case *SetEnv:
 			val := hlib.Value(*x)
-			wcfg.Stmts = append(wcfg.Stmts, &hlib.SetStmt{Value: val})
+			*scoped = append(*scoped, &hlib.SetStmt{Value: val})
 
 		case *SetAppend:
 			val := hlib.Value(*x)
-			wcfg.Stmts = append(wcfg.Stmts, &hlib.SetAppendStmt{Value: val})
+			*scoped = append(*scoped, &hlib.SetAppendStmt{Value: val})
 
 		case *SetRemove:
 			val := hlib.Value(*x)
-			wcfg.Stmts = append(wcfg.Stmts, &hlib.SetRemoveStmt{Value: val})
+			*scoped = append(*scoped, &hlib.SetRemoveStmt{Value: val})
+
+		case *MacroRemoveAll:
+			if _, ok := macros[x.Name]; ok {
+				// this will be used by a library or application
+				continue
+			}
+			// hwaf has no macro_remove_all: the macro_remove written instead
+			// only removes the first occurrence
+			r.req.warn(x, fmt.Errorf("macro_remove_all %s: written as a macro_remove (only the first occurrence is removed)", x.Name))
+			val := hlib.Value(*x)
+			*scoped = append(*scoped, &hlib.MacroRemoveStmt{Value: val})
+
+		case *SetPrepend:
+			r.unsupported(x, "set_prepend", x.Name)
+
+		case *SetRemoveRegexp:
+			r.unsupported(x, "set_remove_regexp", x.Name)
+
+		case *MacroRemoveRegexp:
+			r.unsupported(x, "macro_remove_regexp", x.Name)
+
+		case *MacroRemoveAllRegexp:
+			r.unsupported(x, "macro_remove_all_regexp", x.Name)
+
+		case *PathRemoveRegexp:
+			r.unsupported(x, "path_remove_regexp", x.Name)
+
+		case *CleanupScript:
+			r.unsupported(x, "cleanup_script", x.Script)
+
+		case *StructureStrategy:
+			// only drives the layout of CMT checkouts
+
+		case *Language:
+			tool, ok := g_lang_tools[strings.ToLower(x.Name)]
+			if !ok {
+				r.req.diag(x, fmt.Errorf("no waf tool known for language [%s]", x.Name))
+				continue
+			}
+			if !str_is_in_slice(wcfg.Tools, tool) {
+				wcfg.Tools = append(wcfg.Tools, tool)
+			}
+
+		case *SetupScript:
+			// hwaf has no hook running a script from the runtime environment
+			r.unsupported(x, "setup_script", setup_script_path(filepath.Base(wscript.Package.Name), x.Script))
+
+		case *Branches:
+			// directories CMT creates and expects in the package
+			r.note(x, "branches "+strings.Join(x.Name, " "))
+
+		case *SetupStrategy:
+			r.note(x, "setup_strategy "+strings.Join(x.Values, " "))
+
+		case *BuildStrategy:
+			r.note(x, "build_strategy "+strings.Join(x.Values, " "))
 
 		case *Package:
 			// already dealt with
@@ -318,10 +382,10 @@ func (r *Renderer) analyze() error {
 			// FIXME
 
 		case *IncludePaths:
-			wcfg.Stmts = append(wcfg.Stmts, (*hlib.IncludePathStmt)(x))
+			*scoped = append(*scoped, (*hlib.IncludePathStmt)(x))
 
 		case *IncludeDirs:
-			wcfg.Stmts = append(wcfg.Stmts, (*hlib.IncludeDirsStmt)(x))
+			*scoped = append(*scoped, (*hlib.IncludeDirsStmt)(x))
 
 		case *CmtPathPattern:
 			// FIXME
@@ -341,26 +405,44 @@ func (r *Renderer) analyze() error {
 			// already dealt with
 
 		default:
-			return fmt.Errorf("unhandled statement [%v] (type=%T)\ndir=%v", x, x, r.req.Filename)
+			r.req.diag(x, fmt.Errorf("unhandled statement [%v] (type=%T)", x, x))
 		}
 	}
 
-	for _, stmt := range r.req.Stmts {
-		switch stmt := stmt.(type) {
-		case *PathRemove, *MakeFragment, *Pattern, *MacroRemove:
-			r.wscript = true
-			break
+	if wscript.Package.Version == "" {
+		// no version statement: use the one of the package layout
+		wscript.Package.Version = hlib.Version(layout.Version)
+	}
+
+	if len(r.req.Comments) > 0 {
+		r.comments = append(r.comments, comment_anchor{
+			pos:      Pos{File: r.req.Filename},
+			comments: r.req.Comments,
+		})
+	}
+
+	for _, stmt := range stmts {
+		switch x := stmt.(type) {
+		case *PathRemove:
+			r.need_wscript(x, "path_remove "+x.Name)
+		case *MakeFragment:
+			r.need_wscript(x, "make_fragment "+x.Name)
+		case *Pattern:
+			r.need_wscript(x, "pattern "+x.Name)
+		case *MacroRemove:
+			r.need_wscript(x, "macro_remove "+x.Name)
+		case *MacroRemoveAll:
+			r.need_wscript(x, "macro_remove_all "+x.Name)
 		case *Macro:
-			if len(stmt.Set) > 1 {
-				r.wscript = true
-				break
+			if len(x.Set) > 1 {
+				r.need_wscript(x, fmt.Sprintf("macro %s (%d tag alternatives)", x.Name, len(x.Set)))
 			}
 		}
 	}
 
 	// FIXME: refactor ?
 	if strings.HasPrefix(r.pkg.Package.Name, "External") {
-		r.wscript = true
+		r.need_wscript(nil, "External package")
 	}
 
 	// fixups for boost
@@ -378,36 +460,123 @@ func (r *Renderer) analyze() error {
 	return err
 }
 
-func (r *Renderer) render() error {
-	var err error
-	pkgdir := filepath.Dir(filepath.Dir(r.req.Filename))
-	fname := ""
-	render := r.render_hscript
-	if r.wscript {
-		fname = filepath.Join(pkgdir, "hscript.py")
-		render = r.render_wscript
-	} else {
-		fname = filepath.Join(pkgdir, "hscript.yml")
-		render = r.render_hscript
+// anchor records the comments attached to stmt, if any
+func (r *Renderer) anchor(stmt Stmt) {
+	info, ok := r.req.Infos[stmt]
+	if !ok || len(info.Comments) == 0 {
+		return
 	}
+	r.comments = append(r.comments, comment_anchor{
+		key:      stmt_key(stmt),
+		pos:      info.Pos,
+		comments: info.Comments,
+	})
+}
 
-	if is_user_file(fname) {
-		// user generated file.
-		// keep it.
-		fmt.Printf("**warning** file [%s] already present\n", fname)
-		return nil
-	}
+// note records a requirements statement with no hwaf counterpart, which
+// is carried over to the generated script as a comment
+func (r *Renderer) note(stmt Stmt, text string) {
+	r.comments = append(r.comments, comment_anchor{
+		pos:      r.req.Pos(stmt),
+		comments: []string{"# " + text},
+	})
+}
 
-	r.w, err = os.Create(fname)
+// unsupported records a statement hwaf has no equivalent for.
+// it is a warning: the requirements file is valid, only the conversion
+// loses the statement.
+func (r *Renderer) unsupported(stmt Stmt, keyword, name string) {
+	r.req.warn(stmt, fmt.Errorf("no hwaf equivalent for [%s %s] (statement dropped)", keyword, name))
+}
+
+// convert runs a profile converter on stmt, turning panics into errors
+func (r *Renderer) convert(cnv cnvfct_t, stmt Stmt) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("converter panicked: %v", e)
+		}
+	}()
+	err = cnv(&r.pkg, stmt)
+	return err
+}
+
+// render_diags writes the list of problems of a partial conversion as
+// comments, so the generated file carries its own caveats.
+func (r *Renderer) render_diags() error {
+	return write_diags(r.w, r.req)
+}
+
+// write_diags writes the problems of a partial conversion of req as
+// comments
+func write_diags(w io.Writer, req *ReqFile) error {
+	var err error
+	if !req.IsPartial() {
+		return err
+	}
+	errs := req.Errors()
+	_, err = fmt.Fprintf(
+		w,
+		"## WARNING: partial conversion (%d problem(s))\n",
+		len(errs),
+	)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		r.w.Sync()
-		r.w.Close()
-	}()
+	for _, diag := range errs {
+		_, err = fmt.Fprintf(w, "##  %v\n", diag)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "\n")
+	return err
+}
+
+func (r *Renderer) render() error {
+	var err error
+	pkgdir := filepath.Dir(filepath.Dir(r.req.Filename))
+	formats, why := r.format()
+
+	if f, _ := pkg_format(r.pkg.Package.Name); f == AutoFormat {
+		// report what forced the python fallback
+		for _, reason := range r.py_reasons() {
+			logf("** [%s] needs a hscript.py: %s\n", pkgdir, reason)
+		}
+	} else if len(r.why) > 0 && formats[0] == YmlFormat {
+		r.req.warn(nil, fmt.Errorf("hscript.yml requested but python is needed (%s): the hscript.yml may be incomplete", strings.Join(r.py_reasons(), ", ")))
+	}
+
+	for _, format := range formats {
+		fname := filepath.Join(pkgdir, "hscript.yml")
+		render := r.render_hscript
+		if format == PyFormat {
+			fname = filepath.Join(pkgdir, "hscript.py")
+			render = r.render_wscript
+		}
+
+		if is_user_file(fname) {
+			// user generated file.
+			// keep it.
+			logf("**warning** file [%s] already present\n", fname)
+			err = skip_script(pkgdir, fname, "user-written file")
+			if err != nil {
+				return err
+			}
+			continue
+		}
 
-	err = render()
+		buf := new(bytes.Buffer)
+		r.w = buf
+		err = render()
+		if err != nil {
+			return fmt.Errorf("%s: %v", fname, err)
+		}
+
+		err = write_script(pkgdir, fname, why, insert_comments(buf.Bytes(), r.comments))
+		if err != nil {
+			return err
+		}
+	}
 	return err
 }
 
@@ -445,6 +614,24 @@ func render_script(req *ReqFile) error {
 //  $(package_root)/bla
 var g_pkg_src_re = regexp.MustCompile(`([${].*?[}]|[$(].*?[)])/`)
 
+// setup_script_path returns the path of a setup_script of package pkg,
+// relative to the package when it is in the package.
+// CMT looks for relative scripts in the cmt directory of the package.
+// scripts of other packages keep their root variable.
+func setup_script_path(pkg, script string) string {
+	for _, root := range []string{pkg + "ROOT", strings.ToUpper(pkg) + "ROOT", pkg + "_root", "PACKAGE_ROOT"} {
+		for _, prefix := range []string{"${" + root + "}/", "$(" + root + ")/"} {
+			if strings.HasPrefix(script, prefix) {
+				return script[len(prefix):]
+			}
+		}
+	}
+	if filepath.IsAbs(script) || strings.HasPrefix(script, "$") {
+		return script
+	}
+	return filepath.Join("cmt", script)
+}
+
 // sanitize_srcs
 //  sources: the list of source-strings to sanitize
 //  defdir:  the default directory to prepend to these sources
@@ -478,9 +665,10 @@ func sanitize_srcs(sources []string, defdir string) (srcs []string, rest []strin
 	return srcs, rest
 }
 
+// sanitize_env_string rewrites the macro references of a CMT value into
+// hwaf syntax and strips enclosing double quotes
 func sanitize_env_string(v string) string {
-	v = strings.Replace(v, "$(", "${", -1)
-	v = strings.Replace(v, ")", "}", -1)
+	v = ParseValue(v).Hwaf()
 	if strings.HasPrefix(v, `"`) {
 		v = v[1:]
 	}
@@ -509,10 +697,7 @@ func w_distill_tgt(tgt *hlib.Target_t, macros map[string][]Stmt) {
 	type mungefct_t func(s string) string
 
 	env_munge := func(s string) string {
-		out := s
-		out = strings.Replace(out, "$(", "${", -1)
-		out = strings.Replace(out, ")", "}", -1)
-		return out
+		return ParseValue(s).Hwaf()
 	}
 
 	linkopts_munge := func(s string) string {
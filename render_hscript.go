@@ -15,6 +15,11 @@ func (r *Renderer) render_hscript() error {
 	)
 	handle_err(err)
 
+	err = r.render_diags()
+	if err != nil {
+		return err
+	}
+
 	enc := hlib.NewHscriptYmlEncoder(r.w)
 	if enc == nil {
 		return fmt.Errorf("rcore2yml: got nil hlib.HscriptYmlEncoder")
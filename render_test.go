@@ -0,0 +1,505 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hwaf/hwaf/hlib"
+)
+
+func TestInsertComments(t *testing.T) {
+	data := []byte(`## automatically generated by cmt2yml
+## do NOT edit
+
+package: {
+  name: "Control/Foo",
+}
+configure: {
+  env: {
+    foo: "bar",
+    foobar: "baz",
+  },
+}
+`)
+	anchors := []comment_anchor{
+		{key: "Foo", comments: []string{"# the Foo package"}},
+		{key: "foobar", comments: []string{"# foobar", "# is special"}},
+		{key: "nope", pos: Pos{File: "req", Line: 3}, comments: []string{"# lost"}},
+	}
+	expected := `## automatically generated by cmt2yml
+## do NOT edit
+
+package: {
+  # the Foo package
+  name: "Control/Foo",
+}
+configure: {
+  env: {
+    foo: "bar",
+    # foobar
+    # is special
+    foobar: "baz",
+  },
+}
+
+## comments from the requirements file
+## req:3
+# lost
+`
+	out := string(insert_comments(data, anchors))
+	if out != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s\n", expected, out)
+	}
+}
+
+func TestInsertCommentsDuplicates(t *testing.T) {
+	// the comments of: macro Foo_flags, macro_append Foo_flags and
+	// macro Foo_extra (whose value mentions Foo_flags)
+	anchors := []comment_anchor{
+		{key: "Foo_flags", comments: []string{"# declare"}},
+		{key: "Foo_flags", comments: []string{"# append"}},
+		{key: "Foo_extra", comments: []string{"# extra"}},
+	}
+	for _, table := range []struct {
+		data     string
+		expected string
+	}{
+		{
+			data: `## automatically generated by cmt2yml
+## do NOT edit
+
+configure: {
+  env: {
+    Foo_extra: "with Foo_flags inside",
+    Foo_flags: "-O2",
+  },
+}
+build: {
+  env: {
+    Foo_flags: "-g",
+  },
+}
+`,
+			expected: `## automatically generated by cmt2yml
+## do NOT edit
+
+configure: {
+  env: {
+    # extra
+    Foo_extra: "with Foo_flags inside",
+    # declare
+    Foo_flags: "-O2",
+  },
+}
+build: {
+  env: {
+    # append
+    Foo_flags: "-g",
+  },
+}
+`,
+		},
+		{
+			data: `## -*- python -*-
+## automatically generated from a hscript
+## do NOT edit.
+
+def configure(ctx):
+    ctx.hwaf_declare_macro("Foo_extra", (
+      {"default": "with Foo_flags inside"},
+    ))
+    ctx.hwaf_declare_macro("Foo_flags", (
+      {"default": "-O2"},
+    ))
+    ctx.hwaf_macro_append("Foo_flags", (
+      {"default": "-g"},
+    ))
+`,
+			expected: `## -*- python -*-
+## automatically generated from a hscript
+## do NOT edit.
+
+def configure(ctx):
+    # extra
+    ctx.hwaf_declare_macro("Foo_extra", (
+      {"default": "with Foo_flags inside"},
+    ))
+    # declare
+    ctx.hwaf_declare_macro("Foo_flags", (
+      {"default": "-O2"},
+    ))
+    # append
+    ctx.hwaf_macro_append("Foo_flags", (
+      {"default": "-g"},
+    ))
+`,
+		},
+	} {
+		out := string(insert_comments([]byte(table.data), anchors))
+		if out != table.expected {
+			t.Fatalf("expected:\n%s\ngot:\n%s\n", table.expected, out)
+		}
+	}
+}
+
+func TestExpandPatterns(t *testing.T) {
+	req, err := parse_file("testdata/patterns.txt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	r, err := NewRenderer(req)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = r.analyze()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(req.Diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", req.Diags)
+	}
+
+	pat := req.Stmts[1].(*Pattern)
+	stmts := split_pattern(pat.Def)
+	if !reflect.DeepEqual(stmts, []string{
+		"library <name> <files>",
+		`macro <name>_cxxflags "-DPKG=<package>;1"`,
+		`apply_pattern my_inst dir="<dir>"`,
+	}) {
+		t.Fatalf("unexpected pattern statements: %q", stmts)
+	}
+
+	_, tgt := find_tgt(&r.pkg, "FooLib")
+	if tgt == nil {
+		t.Fatalf("no FooLib target")
+	}
+	expected := []hlib.Value{{
+		Name: "FooLib",
+		Set:  []hlib.KeyValue{{Tag: "default", Value: []string{"src/a.cxx", "src/b.cxx"}}},
+	}}
+	if !reflect.DeepEqual(tgt.Source, expected) {
+		t.Fatalf("unexpected sources: %v", tgt.Source)
+	}
+	expected = []hlib.Value{{
+		Name: "FooLib_cxxflags",
+		Set:  []hlib.KeyValue{{Tag: "default", Value: []string{"-DPKG=Foo;1"}}},
+	}}
+	if !reflect.DeepEqual(tgt.CxxFlags, expected) {
+		t.Fatalf("unexpected cxxflags: %v", tgt.CxxFlags)
+	}
+
+	found := false
+	for _, stmt := range r.pkg.Configure.Stmts {
+		if x, ok := stmt.(*hlib.MacroStmt); ok && x.Value.Name == "Foo_inst" {
+			found = true
+			if !reflect.DeepEqual(x.Value.Set[0].Value, []string{"share"}) {
+				t.Fatalf("unexpected Foo_inst value: %v", x.Value)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("no Foo_inst macro")
+	}
+
+	if len(r.pkg.Build.Stmts) != 1 {
+		t.Fatalf("expected 1 build statement. got %d", len(r.pkg.Build.Stmts))
+	}
+	if x, ok := r.pkg.Build.Stmts[0].(*hlib.ApplyPatternStmt); !ok || x.Name != "unknown_pat" {
+		t.Fatalf("unexpected build statement: %#v", r.pkg.Build.Stmts[0])
+	}
+
+	if len(r.comments) != 1 || r.comments[0].key != "FooLib" {
+		t.Fatalf("expected the apply_pattern comment to be moved to FooLib. got %#v", r.comments)
+	}
+}
+
+func TestPatternRegistry(t *testing.T) {
+	reg := NewPatternRegistry()
+	for _, fname := range []string{"testdata/policy.txt", "testdata/policy_dup.txt"} {
+		req, err := parse_file(fname)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		reg.Add(req)
+		switch fname {
+		case "testdata/policy.txt":
+			if len(req.Diags) != 0 {
+				t.Fatalf("unexpected diagnostics: %v", req.Diags)
+			}
+		case "testdata/policy_dup.txt":
+			// identical redefinitions are fine
+			if len(req.Diags) != 1 || !req.Diags[0].Warn {
+				t.Fatalf("expected 1 warning. got %v", req.Diags)
+			}
+			if req.Diags[0].Pos.Line != 4 {
+				t.Fatalf("expected the warning at line 4. got %v", req.Diags[0])
+			}
+		}
+	}
+	if reg.Len() != 2 {
+		t.Fatalf("expected 2 patterns. got %d", reg.Len())
+	}
+	def, ok := reg.Lookup("my_component")
+	if !ok {
+		t.Fatalf("no my_component pattern")
+	}
+	if def.Package != "MyPolicy" || def.Pos.String() != "testdata/policy.txt:3" {
+		t.Fatalf("unexpected pattern origin: %s %v", def.Package, def.Pos)
+	}
+
+	orig := g_patterns
+	defer func() { g_patterns = orig }()
+	g_patterns = reg
+
+	req, err := parse_file("testdata/policy_use.txt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	r, err := NewRenderer(req)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = r.analyze()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(req.Diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", req.Diags)
+	}
+
+	_, tgt := find_tgt(&r.pkg, "BarLib")
+	if tgt == nil {
+		t.Fatalf("no BarLib target")
+	}
+	if len(r.comments) != 1 {
+		t.Fatalf("expected 1 comment. got %#v", r.comments)
+	}
+	note := r.comments[0].comments[0]
+	if !strings.Contains(note, "pattern defined by [MyPolicy] at testdata/policy.txt:3") {
+		t.Fatalf("unexpected note: %q", note)
+	}
+}
+
+func TestVisibility(t *testing.T) {
+	req, err := parse_file("testdata/visibility.txt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	r, err := NewRenderer(req)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = r.analyze()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	cfg := []string{}
+	for _, stmt := range r.pkg.Configure.Stmts {
+		cfg = append(cfg, reflect.TypeOf(stmt).String())
+	}
+	expected := []string{
+		"*hlib.MacroStmt", "*hlib.SetStmt", "*hlib.IncludeDirsStmt", "*hlib.PathPrependStmt",
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Fatalf("expected configure statements %v. got %v", expected, cfg)
+	}
+
+	bld := []string{}
+	for _, stmt := range r.pkg.Build.Stmts {
+		bld = append(bld, reflect.TypeOf(stmt).String())
+	}
+	expected = []string{
+		"*hlib.MacroStmt", "*hlib.MacroAppendStmt", "*hlib.SetStmt", "*hlib.PathAppendStmt", "*hlib.IncludeDirsStmt",
+	}
+	if !reflect.DeepEqual(bld, expected) {
+		t.Fatalf("expected build statements %v. got %v", expected, bld)
+	}
+}
+
+func TestRenderTaggedFallback(t *testing.T) {
+	const fname = "testdata/format/Tools/Tagged/cmt/requirements"
+	req, err := parse_file(fname)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	r, err := NewRenderer(req)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = r.analyze()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// the hscript.yml encoder does not know about tag alternatives and
+	// removals: they need a hscript.py
+	expected := []string{
+		"macro Tagged_cppflags (2 tag alternatives) at " + fname + ":3",
+		"macro_remove Tagged_cppflags at " + fname + ":6",
+		"path_remove PATH at " + fname + ":9",
+	}
+	if got := r.py_reasons(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected python reasons:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	// the statements reach the encoder in the order of the requirements
+	// file, with their tag alternatives
+	describe := func(stmts []interface{}) []string {
+		o := []string{}
+		for _, stmt := range stmts {
+			v := reflect.ValueOf(stmt).Elem().FieldByName("Value").Interface().(hlib.Value)
+			o = append(o, fmt.Sprintf("%T %s %v", stmt, v.Name, v.Set))
+		}
+		return o
+	}
+	cfg := []interface{}{}
+	for _, stmt := range r.pkg.Configure.Stmts {
+		cfg = append(cfg, stmt)
+	}
+	expected = []string{
+		"*hlib.MacroStmt Tagged_cppflags [{default [-DTAGGED]} {x86_64&linux&slc6 [-DTAGGED64]}]",
+		"*hlib.MacroAppendStmt Tagged_cppflags [{default [-DMORE]}]",
+		"*hlib.MacroRemoveStmt Tagged_cppflags [{default [-DTAGGED]}]",
+	}
+	if got := describe(cfg); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected configure statements:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+	bld := []interface{}{}
+	for _, stmt := range r.pkg.Build.Stmts {
+		bld = append(bld, stmt)
+	}
+	expected = []string{
+		"*hlib.PathRemoveStmt PATH [{default [/opt/old]}]",
+		"*hlib.PathAppendStmt PATH [{default [/opt/new]}]",
+	}
+	if got := describe(bld); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected build statements:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestPatternScopes(t *testing.T) {
+	req, err := parse_file("testdata/pattern_scopes.txt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	r, err := NewRenderer(req)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = r.analyze()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(req.Diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", req.Diags)
+	}
+
+	names := func(stmts []interface{}) []string {
+		o := []string{}
+		for _, stmt := range stmts {
+			if x, ok := stmt.(*hlib.MacroStmt); ok {
+				o = append(o, x.Value.Name)
+			}
+		}
+		return o
+	}
+	cfg := []interface{}{}
+	for _, stmt := range r.pkg.Configure.Stmts {
+		cfg = append(cfg, stmt)
+	}
+	bld := []interface{}{}
+	for _, stmt := range r.pkg.Build.Stmts {
+		bld = append(bld, stmt)
+	}
+
+	// private in the pattern body, or applied from a private section
+	expected := []string{"pub_priv", "priv_priv", "priv_pub"}
+	if got := names(bld); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected private macros %v. got %v", expected, got)
+	}
+	expected = []string{"pub_pub"}
+	if got := names(cfg); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected public macros %v. got %v", expected, got)
+	}
+}
+
+func TestSetupScript(t *testing.T) {
+	orig := g_profile
+	defer func() { g_profile = orig }()
+
+	for _, profile := range []string{"atlasoff", "tdaq"} {
+		g_profile = g_profiles[profile]
+
+		req, err := parse_file("testdata/setup/Foo/cmt/requirements")
+		if err != nil {
+			t.Fatalf("%s: %v", profile, err)
+		}
+		r, err := NewRenderer(req)
+		if err != nil {
+			t.Fatalf("%s: %v", profile, err)
+		}
+		err = r.analyze()
+		if err != nil {
+			t.Fatalf("%s: %v", profile, err)
+		}
+		if len(r.pkg.Build.Targets) != 0 {
+			t.Fatalf("%s: unexpected targets: %v", profile, r.pkg.Build.Targets)
+		}
+
+		if req.IsPartial() {
+			t.Fatalf("%s: unexpected errors: %v", profile, req.Errors())
+		}
+		msgs := []string{}
+		for _, diag := range req.Diags {
+			msgs = append(msgs, diag.Msg)
+		}
+		expected := []string{}
+		for _, script := range []string{
+			"cmt/setup_foo", "scripts/setup_bar", "share/setup_baz", "/opt/setup_qux",
+			// another package: keep its root
+			"${BARROOT}/cmt/setup_other",
+		} {
+			expected = append(expected, "no hwaf equivalent for [setup_script "+script+"] (statement dropped)")
+		}
+		if !reflect.DeepEqual(msgs, expected) {
+			t.Fatalf("%s: expected diagnostics:\n%s\ngot:\n%s", profile, strings.Join(expected, "\n"), strings.Join(msgs, "\n"))
+		}
+	}
+}
+
+func TestRenderUnsupported(t *testing.T) {
+	const fname = "testdata/unsupported.txt"
+	req, err := parse_file(fname)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	r, err := NewRenderer(req)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = r.analyze()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// the requirements file is valid: the conversion is not partial
+	if req.IsPartial() {
+		t.Fatalf("unexpected errors: %v", req.Errors())
+	}
+	diags := []string{}
+	for _, diag := range req.Diags {
+		diags = append(diags, diag.Error())
+	}
+	expected := []string{
+		fname + ":3: no hwaf equivalent for [set_prepend PATH] (statement dropped)",
+		fname + ":4: macro_remove_all Unsupported_cppflags: written as a macro_remove (only the first occurrence is removed)",
+	}
+	if !reflect.DeepEqual(diags, expected) {
+		t.Fatalf("expected warnings:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(diags, "\n"))
+	}
+}
+
+// EOF
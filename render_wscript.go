@@ -19,6 +19,8 @@ func (r *Renderer) render_wscript() error {
 		return err
 	}
 
+	// the encoder owns the file header: caveats go at the end
+	err = r.render_diags()
 	return err
 }
 
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Scope is the visibility of a requirements statement.
+// private statements only apply to the package itself, public ones are
+// also seen by its clients.
+type Scope int
+
+const (
+	PublicScope Scope = iota
+	PrivateScope
+)
+
+func (s Scope) String() string {
+	switch s {
+	case PublicScope:
+		return tok_BEG_PUBLIC
+	case PrivateScope:
+		return tok_BEG_PRIVATE
+	}
+	return fmt.Sprintf("Scope(%d)", int(s))
+}
+
+// scope_ctx is a private or public section being parsed
+type scope_ctx struct {
+	scope Scope
+	pos   Pos // location of the statement opening the section
+}
+
+// Scope returns the visibility of a statement
+func (req *ReqFile) Scope(stmt Stmt) Scope {
+	if info, ok := req.Infos[stmt]; ok {
+		return info.Scope
+	}
+	return PublicScope
+}
+
+// scope returns the visibility of the statement being parsed
+func (p *Parser) scope() Scope {
+	return p.ctx[len(p.ctx)-1].scope
+}
+
+// open_scope switches to a private or public section.
+// as for CMT, sections need not be closed: 'private' and 'public' toggle
+// the visibility of the statements which follow, up to the next toggle or
+// to the end of the file.
+// the previous sections are kept, for end_private and end_public to get
+// back to them.
+// switching to the current scope is reported as redundant.
+func (p *Parser) open_scope(scope Scope) {
+	cur := p.ctx[len(p.ctx)-1]
+	if cur.scope == scope {
+		if len(p.ctx) == 1 {
+			p.warnf("redundant %s section (statements are %s by default)", scope, scope)
+		} else {
+			p.warnf("redundant %s section (already %s since line %d)", scope, scope, cur.pos.Line)
+		}
+	}
+	p.ctx = append(p.ctx, scope_ctx{scope: scope, pos: p.pos})
+}
+
+// close_scope closes the current section, which should be of the given
+// scope, and gets back to the previous one.
+// closing statements with no open section are reported and ignored.
+func (p *Parser) close_scope(scope Scope) {
+	if len(p.ctx) <= 1 {
+		p.warnf("unbalanced end_%s (no %s section is open)", scope, scope)
+		return
+	}
+	cur := p.ctx[len(p.ctx)-1]
+	if cur.scope != scope {
+		p.warnf("end_%s closes the %s section opened at line %d", scope, cur.scope, cur.pos.Line)
+	}
+	p.ctx = p.ctx[:len(p.ctx)-1]
+}
+
+// end_scopes reports a private section still open at the end of the file.
+// as for CMT, it extends up to the end of the file.
+// sections left by a toggle are not reported.
+func (p *Parser) end_scopes() {
+	cur := p.ctx[len(p.ctx)-1]
+	if cur.scope == PrivateScope {
+		p.req.Diags = append(p.req.Diags, Diag{
+			Pos:  cur.pos,
+			Msg:  fmt.Sprintf("%s section is never closed (%s up to the end of the file)", cur.scope, cur.scope),
+			Warn: true,
+		})
+	}
+	p.ctx = p.ctx[:1]
+}
+
+// EOF
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"io"
 	"strings"
 
@@ -10,7 +11,7 @@ import (
 const (
 	tok_BEG_PRIVATE = "private"
 	tok_BEG_PUBLIC  = "public"
-	tok_END_PRIVATE = "private"
+	tok_END_PRIVATE = "end_private"
 	tok_END_PUBLIC  = "end_public"
 )
 
@@ -18,14 +19,47 @@ type ReqFile struct {
 	Filename string
 	Package  Package
 	Stmts    []Stmt
+	Infos    map[Stmt]*StmtInfo // parsing metadata, indexed by statement
+	Diags    []Diag             // problems found while parsing and converting
+	Comments []string           // comments not attached to any statement
 }
 
 func NewReqFile(name string) ReqFile {
 	return ReqFile{
 		Package: Package{name},
+		Infos:   make(map[Stmt]*StmtInfo),
 	}
 }
 
+// Pos returns the position of a statement in the requirements file
+func (req *ReqFile) Pos(stmt Stmt) Pos {
+	if info, ok := req.Infos[stmt]; ok {
+		return info.Pos
+	}
+	return Pos{File: req.Filename}
+}
+
+// Pos describes the location of a statement in a requirements file
+type Pos struct {
+	File string
+	Line int // first line of the statement
+	End  int // last line of the statement (including line continuations)
+}
+
+func (pos Pos) String() string {
+	if pos.Line <= 0 {
+		return pos.File
+	}
+	return fmt.Sprintf("%s:%d", pos.File, pos.Line)
+}
+
+// StmtInfo holds the parsing metadata attached to a statement
+type StmtInfo struct {
+	Pos      Pos
+	Scope    Scope    // private or public
+	Comments []string // comments preceding (or trailing) the statement
+}
+
 func (req *ReqFile) ToYaml(w io.Writer) error {
 	var err error
 	return err
@@ -79,6 +113,14 @@ var g_dispatch = map[string]ParseFunc{
 	"setup_script":            parseSetupScript,
 	"setup_strategy":          parseSetupStrategy,
 	"build_strategy":          parseBuildStrategy,
+	"set_prepend":             parseSetPrepend,
+	"set_remove_regexp":       parseSetRemoveRegexp,
+	"macro_remove_all":        parseMacroRemoveAll,
+	"macro_remove_regexp":     parseMacroRemoveRegexp,
+	"macro_remove_all_regexp": parseMacroRemoveAllRegexp,
+	"path_remove_regexp":      parsePathRemoveRegexp,
+	"cleanup_script":          parseCleanupScript,
+	"structure_strategy":      parseStructureStrategy,
 }
 
 type Package struct {
@@ -141,7 +183,9 @@ func (s *Branches) ToYaml(w io.Writer) error {
 
 func parseBranches(p *Parser) error {
 	var err error
-	// just ignore.
+	vv := Branches{Name: make([]string, len(p.tokens[1:]))}
+	copy(vv.Name, p.tokens[1:])
+	p.req.Stmts = append(p.req.Stmts, &vv)
 	return err
 }
 
@@ -180,7 +224,17 @@ func (s *UsePkg) ToYaml(w io.Writer) error {
 
 func parseUse(p *Parser) error {
 	var err error
-	tokens := p.tokens
+	// switches (-no_auto_imports, -native_version=..., ...) may appear
+	// anywhere after the package name.
+	tokens := make([]string, 0, len(p.tokens))
+	switches := []string{}
+	for i, tok := range p.tokens {
+		if i > 1 && strings.HasPrefix(tok, "-") {
+			switches = append(switches, tok)
+			continue
+		}
+		tokens = append(tokens, tok)
+	}
 	use := &UsePkg{Package: tokens[1]}
 	if len(tokens) > 2 {
 		use.Version = tokens[2]
@@ -189,7 +243,11 @@ func parseUse(p *Parser) error {
 		use.Path = tokens[3]
 	}
 	if len(tokens) > 4 {
-		use.Switches = append(use.Switches, tokens[4:]...)
+		// keep the dependency: only the extra arguments are dropped
+		p.warnf("too many arguments to use statement: %v (ignored)", tokens[4:])
+	}
+	if len(switches) > 0 {
+		use.Switches = switches
 	}
 	p.req.Stmts = append(p.req.Stmts, use)
 	return err
@@ -251,6 +309,50 @@ func parseMacroRemove(p *Parser) error {
 	return err
 }
 
+type MacroRemoveAll hlib.Value
+
+func (s *MacroRemoveAll) ToYaml(w io.Writer) error {
+	return nil
+}
+
+func parseMacroRemoveAll(p *Parser) error {
+	var err error
+	tokens := p.tokens
+	vv := MacroRemoveAll(hlib_value_from_slice(tokens[1], sanitize_env_strings(tokens[2:])))
+	p.req.Stmts = append(p.req.Stmts, &vv)
+	return err
+}
+
+type MacroRemoveRegexp hlib.Value
+
+func (s *MacroRemoveRegexp) ToYaml(w io.Writer) error {
+	return nil
+}
+
+func parseMacroRemoveRegexp(p *Parser) error {
+	var err error
+	tokens := p.tokens
+	// regexps are kept verbatim
+	vv := MacroRemoveRegexp(hlib_value_from_slice(tokens[1], tokens[2:]))
+	p.req.Stmts = append(p.req.Stmts, &vv)
+	return err
+}
+
+type MacroRemoveAllRegexp hlib.Value
+
+func (s *MacroRemoveAllRegexp) ToYaml(w io.Writer) error {
+	return nil
+}
+
+func parseMacroRemoveAllRegexp(p *Parser) error {
+	var err error
+	tokens := p.tokens
+	// regexps are kept verbatim
+	vv := MacroRemoveAllRegexp(hlib_value_from_slice(tokens[1], tokens[2:]))
+	p.req.Stmts = append(p.req.Stmts, &vv)
+	return err
+}
+
 type IncludeDirs hlib.IncludeDirsStmt
 
 func (s *IncludeDirs) ToYaml(w io.Writer) error {
@@ -337,6 +439,35 @@ func parseSetRemove(p *Parser) error {
 	return err
 }
 
+type SetPrepend hlib.Value
+
+func (s *SetPrepend) ToYaml(w io.Writer) error {
+	return nil
+}
+
+func parseSetPrepend(p *Parser) error {
+	var err error
+	tokens := p.tokens
+	vv := SetPrepend(hlib_value_from_slice(tokens[1], sanitize_env_strings(tokens[2:])))
+	p.req.Stmts = append(p.req.Stmts, &vv)
+	return err
+}
+
+type SetRemoveRegexp hlib.Value
+
+func (s *SetRemoveRegexp) ToYaml(w io.Writer) error {
+	return nil
+}
+
+func parseSetRemoveRegexp(p *Parser) error {
+	var err error
+	tokens := p.tokens
+	// regexps are kept verbatim
+	vv := SetRemoveRegexp(hlib_value_from_slice(tokens[1], tokens[2:]))
+	p.req.Stmts = append(p.req.Stmts, &vv)
+	return err
+}
+
 type Pattern struct {
 	Name string
 	Def  string
@@ -348,13 +479,28 @@ func (s *Pattern) ToYaml(w io.Writer) error {
 
 func parsePattern(p *Parser) error {
 	var err error
-	tokens := p.tokens
-	if tokens[1][0] == '-' {
-		tokens[1], tokens[2] = tokens[2], tokens[1]
+	// the definition is kept verbatim (quotes included) so it can be
+	// instantiated and re-parsed by apply_pattern statements.
+	lex := newLexer(p.line)
+	lex.next() // pattern keyword
+	name := ""
+	for {
+		tok, ok := lex.next()
+		if !ok {
+			break
+		}
+		if tok == "-global" {
+			continue
+		}
+		name = tok
+		break
+	}
+	if name == "" {
+		return fmt.Errorf("pattern without a name")
 	}
 	vv := Pattern{
-		Name: tokens[1],
-		Def:  strings.Join(tokens[2:], " "),
+		Name: name,
+		Def:  strings.TrimSpace(string(p.line[lex.pos:])),
 	}
 	p.req.Stmts = append(p.req.Stmts, &vv)
 	return err
@@ -437,6 +583,21 @@ func parsePathRemove(p *Parser) error {
 	return err
 }
 
+type PathRemoveRegexp hlib.Value
+
+func (s *PathRemoveRegexp) ToYaml(w io.Writer) error {
+	return nil
+}
+
+func parsePathRemoveRegexp(p *Parser) error {
+	var err error
+	tokens := p.tokens
+	// regexps are kept verbatim
+	vv := PathRemoveRegexp(hlib_value_from_slice(tokens[1], tokens[2:]))
+	p.req.Stmts = append(p.req.Stmts, &vv)
+	return err
+}
+
 type PathPrepend hlib.Value
 
 func (s *PathPrepend) ToYaml(w io.Writer) error {
@@ -577,6 +738,12 @@ func parseDocument(p *Parser) error {
 }
 */
 
+// Document models:
+//
+//	document <fragment> <name> [-group=...] [-s=...] [-target_tag] [k=v...] [sources...]
+//
+// Name holds the fragment and Args the document name, followed by the
+// options and then the remaining arguments.
 type Document hlib.DocumentStmt
 
 func (s *Document) ToYaml(w io.Writer) error {
@@ -585,15 +752,26 @@ func (s *Document) ToYaml(w io.Writer) error {
 
 func parseDocument(p *Parser) error {
 	var err error
-	tokens := p.tokens
-	if tokens[1][0] == '-' {
-		tokens[1], tokens[2] = tokens[2], tokens[1]
+	// options may appear anywhere after the keyword.
+	opts := []string{}
+	args := []string{}
+	for _, tok := range p.tokens[1:] {
+		if strings.HasPrefix(tok, "-") {
+			opts = append(opts, tok)
+		} else {
+			args = append(args, tok)
+		}
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("document needs a fragment and a name (got %v)", p.tokens[1:])
 	}
 	vv := Document{
-		Name: tokens[1],
-		Args: make([]string, len(tokens[2:])),
+		Name: args[0],
+		Args: make([]string, 0, len(p.tokens[2:])),
 	}
-	copy(vv.Args, tokens[2:])
+	vv.Args = append(vv.Args, args[1])
+	vv.Args = append(vv.Args, opts...)
+	vv.Args = append(vv.Args, args[2:]...)
 	p.req.Stmts = append(p.req.Stmts, &vv)
 	return err
 }
@@ -610,7 +788,7 @@ func parseCmtPathPattern(p *Parser) error {
 	var err error
 	tokens := p.tokens
 	vv := CmtPathPattern{}
-	vv.Cmd = append(vv.Cmd, sanitize_env_strings(tokens[2:])...)
+	vv.Cmd = append(vv.Cmd, sanitize_env_strings(tokens[1:])...)
 	p.req.Stmts = append(p.req.Stmts, &vv)
 	return err
 }
@@ -627,7 +805,7 @@ func parseCmtPathPatternReverse(p *Parser) error {
 	var err error
 	tokens := p.tokens
 	vv := CmtPathPatternReverse{}
-	vv.Cmd = append(vv.Cmd, sanitize_env_strings(tokens[2:])...)
+	vv.Cmd = append(vv.Cmd, sanitize_env_strings(tokens[1:])...)
 	p.req.Stmts = append(p.req.Stmts, &vv)
 	return err
 }
@@ -654,7 +832,7 @@ func (s *BeginPrivate) ToYaml(w io.Writer) error {
 
 func parsePrivate(p *Parser) error {
 	var err error
-	p.ctx = append(p.ctx, tok_BEG_PRIVATE)
+	p.open_scope(PrivateScope)
 	vv := BeginPrivate(tok_BEG_PRIVATE)
 	p.req.Stmts = append(p.req.Stmts, &vv)
 	return err
@@ -668,7 +846,7 @@ func (s *EndPrivate) ToYaml(w io.Writer) error {
 
 func parseEndPrivate(p *Parser) error {
 	var err error
-	p.ctx = p.ctx[:len(p.ctx)-1]
+	p.close_scope(PrivateScope)
 	vv := EndPrivate(tok_END_PRIVATE)
 	p.req.Stmts = append(p.req.Stmts, &vv)
 	return err
@@ -682,7 +860,7 @@ func (s *BeginPublic) ToYaml(w io.Writer) error {
 
 func parsePublic(p *Parser) error {
 	var err error
-	p.ctx = append(p.ctx, tok_BEG_PUBLIC)
+	p.open_scope(PublicScope)
 	vv := BeginPublic(tok_BEG_PUBLIC)
 	p.req.Stmts = append(p.req.Stmts, &vv)
 	return err
@@ -696,33 +874,110 @@ func (s *EndPublic) ToYaml(w io.Writer) error {
 
 func parseEndPublic(p *Parser) error {
 	var err error
-	p.ctx = p.ctx[:len(p.ctx)-1]
+	p.close_scope(PublicScope)
 	vv := EndPublic(tok_END_PUBLIC)
 	p.req.Stmts = append(p.req.Stmts, &vv)
 	return err
 }
 
+type CleanupScript struct {
+	Script string
+}
+
+func (s *CleanupScript) ToYaml(w io.Writer) error {
+	return nil
+}
+
+func parseCleanupScript(p *Parser) error {
+	var err error
+	tokens := p.tokens
+	vv := CleanupScript{Script: sanitize_env_string(tokens[1])}
+	p.req.Stmts = append(p.req.Stmts, &vv)
+	return err
+}
+
+type StructureStrategy struct {
+	Value string
+}
+
+func (s *StructureStrategy) ToYaml(w io.Writer) error {
+	return nil
+}
+
+func parseStructureStrategy(p *Parser) error {
+	var err error
+	tokens := p.tokens
+	vv := StructureStrategy{Value: tokens[1]}
+	p.req.Stmts = append(p.req.Stmts, &vv)
+	return err
+}
+
+type SetupScript struct {
+	Script string
+}
+
+func (s *SetupScript) ToYaml(w io.Writer) error {
+	return nil
+}
+
 func parseSetupScript(p *Parser) error {
 	var err error
-	// just ignore.
+	tokens := p.tokens
+	vv := SetupScript{Script: sanitize_env_string(tokens[1])}
+	p.req.Stmts = append(p.req.Stmts, &vv)
 	return err
 }
 
+type SetupStrategy struct {
+	Values []string
+}
+
+func (s *SetupStrategy) ToYaml(w io.Writer) error {
+	return nil
+}
+
 func parseSetupStrategy(p *Parser) error {
 	var err error
-	// just ignore.
+	vv := SetupStrategy{Values: make([]string, len(p.tokens[1:]))}
+	copy(vv.Values, p.tokens[1:])
+	p.req.Stmts = append(p.req.Stmts, &vv)
 	return err
 }
 
+type BuildStrategy struct {
+	Values []string
+}
+
+func (s *BuildStrategy) ToYaml(w io.Writer) error {
+	return nil
+}
+
 func parseBuildStrategy(p *Parser) error {
 	var err error
-	// just ignore.
+	vv := BuildStrategy{Values: make([]string, len(p.tokens[1:]))}
+	copy(vv.Values, p.tokens[1:])
+	p.req.Stmts = append(p.req.Stmts, &vv)
 	return err
 }
 
+// Language models:
+//
+//	language <name> [-suffix=...] [-linker=...] [-fragment=...] [...]
+type Language struct {
+	Name string
+	Args []string
+}
+
+func (s *Language) ToYaml(w io.Writer) error {
+	return nil
+}
+
 func parseLanguage(p *Parser) error {
 	var err error
-	// just ignore.
+	tokens := p.tokens
+	vv := Language{Name: tokens[1]}
+	vv.Args = append(vv.Args, tokens[2:]...)
+	p.req.Stmts = append(p.req.Stmts, &vv)
 	return err
 }
 
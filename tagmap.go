@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hwaf/hwaf/hlib"
+)
+
+// TagTable translates CMT tags into hwaf tags.
+// keys are CMT tags or CMTCONFIG components (arch, os, compiler, build
+// type), e.g. x86_64-slc5, slc5, gcc43 or opt.
+// values are hwaf tags. an empty value marks an obsolete platform:
+// tag alternatives requiring it are dropped.
+type TagTable map[string]string
+
+// g_cmt_tags holds the translations common to all profiles
+var g_cmt_tags = TagTable{
+	// operating systems, as reported by uname
+	"Linux":  "linux",
+	"Darwin": "darwin",
+
+	// build types
+	"debug":      "dbg",
+	"target-opt": "opt",
+	"target-dbg": "dbg",
+
+	// obsolete platforms
+	"slc3":  "",
+	"slc4":  "",
+	"rh73":  "",
+	"gcc32": "",
+	"gcc34": "",
+}
+
+// g_lcg_tags holds the translations of the components of the LCG
+// CMTCONFIG platforms, e.g. x86_64-slc6-gcc47-opt, shared by the profiles
+// building on them.
+// hwaf tags a platform by its components, compilers by their family and
+// version.
+var g_lcg_tags = TagTable{
+	// architectures
+	"ia32":  "i686",
+	"amd64": "x86_64",
+
+	// operating systems
+	"slc5": "linux&slc5",
+	"slc6": "linux&slc6",
+
+	// compilers
+	"gcc41":   "gcc&gcc41",
+	"gcc43":   "gcc&gcc43",
+	"gcc44":   "gcc&gcc44",
+	"gcc45":   "gcc&gcc45",
+	"gcc46":   "gcc&gcc46",
+	"gcc47":   "gcc&gcc47",
+	"gcc48":   "gcc&gcc48",
+	"icc11":   "icc&icc11",
+	"icc12":   "icc&icc12",
+	"icc13":   "icc&icc13",
+	"clang30": "clang&clang30",
+	"clang31": "clang&clang31",
+	"clang32": "clang&clang32",
+}
+
+// new_tag_table returns a table holding the common translations,
+// overridden by the given ones
+func new_tag_table(tbls ...TagTable) TagTable {
+	out := make(TagTable, len(g_cmt_tags))
+	for _, tbl := range append([]TagTable{g_cmt_tags}, tbls...) {
+		for k, v := range tbl {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// load_tag_table reads a tag translation file.
+// each line holds a CMT tag and its hwaf translation, or '-' for an
+// obsolete platform:
+//
+//	# CMT tag     hwaf tag
+//	x86_64-slc5   x86_64&slc5
+//	icc11         icc
+//	ppc-rtems     -
+func load_tag_table(fname string) (TagTable, error) {
+	tbl, err := read_table(fname, "a CMT tag and its hwaf translation")
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range tbl {
+		if v == "-" {
+			tbl[k] = ""
+		}
+	}
+	return TagTable(tbl), err
+}
+
+// Translate translates a CMT tag expression into a hwaf one.
+// It returns false if the expression requires an obsolete platform, i.e.
+// if it can not match anymore.
+func (tbl TagTable) Translate(tag string) (string, bool, error) {
+	expr, err := ParseTagExpr(tag)
+	if err != nil {
+		return tag, true, err
+	}
+
+	terms := []string{}
+	add := func(term string) {
+		if !str_is_in_slice(terms, term) {
+			terms = append(terms, term)
+		}
+	}
+	for _, term := range expr.Terms {
+		parts, ok := tbl.translate_term(term)
+		switch {
+		case !ok && term.Neg:
+			// negating an obsolete platform always matches
+			continue
+		case !ok:
+			return tag, false, nil
+		case term.Neg && len(parts) > 1 && len(term.Parts) == 1 && str_is_in_slice(parts, term.Parts[0]):
+			// a component translated along with the tags it implies,
+			// e.g. gcc43 as gcc&gcc43: negate the component alone
+			add("!" + term.Parts[0])
+		case term.Neg && len(parts) > 1:
+			return tag, true, fmt.Errorf("can not translate negated tag [%s]", term)
+		case term.Neg:
+			add("!" + parts[0])
+		default:
+			for _, part := range parts {
+				add(part)
+			}
+		}
+	}
+	if len(terms) == 0 {
+		return tag, true, fmt.Errorf("tag [%s] always matches once obsolete platforms are removed", tag)
+	}
+	return strings.Join(terms, "&"), true, nil
+}
+
+// translate_term translates a term into a list of hwaf tags, all of
+// which have to be active.
+// the longest runs of components with a translation are translated first,
+// e.g. x86_64-slc5-gcc43 is translated as x86_64-slc5 and gcc43 if the
+// table knows about x86_64-slc5.
+func (tbl TagTable) translate_term(term TagTerm) ([]string, bool) {
+	parts := []string{}
+	for i := 0; i < len(term.Parts); {
+		n := len(term.Parts) - i
+		for ; n > 0; n-- {
+			if _, ok := tbl[strings.Join(term.Parts[i:i+n], "-")]; ok {
+				break
+			}
+		}
+		if n == 0 {
+			parts = append(parts, term.Parts[i])
+			i++
+			continue
+		}
+		v := tbl[strings.Join(term.Parts[i:i+n], "-")]
+		if v == "" {
+			return nil, false
+		}
+		parts = append(parts, strings.Split(v, "&")...)
+		i += n
+	}
+	return parts, true
+}
+
+// translate_tags returns the statements with their tag alternatives
+// translated into hwaf tags.
+// statements with a value are copied, not modified, so the requirements
+// file keeps its CMT tags and values.
+func (r *Renderer) translate_tags(stmts []Stmt) []Stmt {
+	tbl := g_profile.tags
+	if tbl == nil {
+		tbl = new_tag_table()
+	}
+	out := make([]Stmt, 0, len(stmts))
+	for _, stmt := range stmts {
+		v := stmt_value(stmt)
+		if v == nil {
+			out = append(out, stmt)
+			continue
+		}
+		set := make([]hlib.KeyValue, 0, len(v.Set))
+		for _, kv := range v.Set {
+			if kv.Tag == "default" {
+				set = append(set, kv)
+				continue
+			}
+			tag, ok, err := tbl.Translate(kv.Tag)
+			if err != nil {
+				r.req.warn(stmt, fmt.Errorf("%s: %v", v.Name, err))
+			}
+			if !ok {
+				r.req.warn(stmt, fmt.Errorf("%s: tag alternative [%s] dropped (obsolete platform)", v.Name, kv.Tag))
+				continue
+			}
+			kv.Tag = tag
+			set = append(set, kv)
+		}
+
+		// the later passes rewrite the values in place: always work on a
+		// copy
+		x := copy_stmt(r.req, stmt)
+		stmt_value(x).Set = copy_set(set)
+		out = append(out, x)
+	}
+	return out
+}
+
+// copy_stmt returns a copy of a statement, sharing its position and
+// comments.
+// its value and arguments are copied too, so that they can be rewritten.
+func copy_stmt(req *ReqFile, stmt Stmt) Stmt {
+	cp := reflect.New(reflect.TypeOf(stmt).Elem())
+	cp.Elem().Set(reflect.ValueOf(stmt).Elem())
+	x := cp.Interface().(Stmt)
+	if v := stmt_value(x); v != nil {
+		v.Set = copy_set(v.Set)
+	}
+	if v, ok := x.(*ApplyPattern); ok {
+		v.Args = append(v.Args[:0:0], v.Args...)
+	}
+	if info, ok := req.Infos[stmt]; ok {
+		req.Infos[x] = info
+	}
+	return x
+}
+
+// copy_set returns a copy of the tag alternatives of a value
+func copy_set(set []hlib.KeyValue) []hlib.KeyValue {
+	out := make([]hlib.KeyValue, len(set))
+	for i, kv := range set {
+		out[i] = hlib.KeyValue{Tag: kv.Tag, Value: append(kv.Value[:0:0], kv.Value...)}
+	}
+	return out
+}
+
+// EOF
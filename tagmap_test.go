@@ -0,0 +1,188 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hwaf/hwaf/hlib"
+)
+
+func TestTagTableTranslate(t *testing.T) {
+	tbl := new_tag_table(TagTable{
+		"x86_64-slc5": "x86_64&linux&slc5",
+		"icc11":       "icc",
+		"ppc-rtems":   "",
+	})
+	for _, table := range []struct {
+		tag      string
+		expected string
+		ok       bool
+		err      bool
+	}{
+		{tag: "x86_64-slc6&gcc47", expected: "x86_64&slc6&gcc47", ok: true},
+		{tag: "x86_64-slc5&gcc43", expected: "x86_64&linux&slc5&gcc43", ok: true},
+		{tag: "icc11&opt", expected: "icc&opt", ok: true},
+		{tag: "Linux&target-dbg", expected: "linux&dbg", ok: true},
+		{tag: "x86_64&x86_64-slc6", expected: "x86_64&slc6", ok: true},
+		{tag: "!icc11", expected: "!icc", ok: true},
+		{tag: "!slc4&gcc43", expected: "gcc43", ok: true},
+		{tag: "i686-slc4&gcc34", expected: "i686-slc4&gcc34", ok: false},
+		{tag: "ppc-rtems-rce405", expected: "ppc-rtems-rce405", ok: false},
+		{tag: "x86_64-slc5-gcc43-opt", expected: "x86_64&linux&slc5&gcc43&opt", ok: true},
+		{tag: "ppc-rtems", expected: "ppc-rtems", ok: false},
+		{tag: "!x86_64-slc6", expected: "!x86_64-slc6", ok: true, err: true},
+		{tag: "!slc4", expected: "!slc4", ok: true, err: true},
+		{tag: "a&&b", expected: "a&&b", ok: true, err: true},
+	} {
+		tag, ok, err := tbl.Translate(table.tag)
+		if (err != nil) != table.err {
+			t.Fatalf("%s: unexpected error: %v", table.tag, err)
+		}
+		if tag != table.expected || ok != table.ok {
+			t.Fatalf("%s: expected (%q, %v). got (%q, %v)", table.tag, table.expected, table.ok, tag, ok)
+		}
+	}
+}
+
+func TestProfileTagTables(t *testing.T) {
+	for _, table := range []struct {
+		profile  string
+		tag      string
+		expected string
+		ok       bool
+	}{
+		{"atlasoff", "x86_64-slc6-gcc47-opt", "x86_64&linux&slc6&gcc&gcc47&opt", true},
+		{"atlasoff", "x86_64-slc5-gcc43-dbg", "x86_64&linux&slc5&gcc&gcc43&dbg", true},
+		{"atlasoff", "i686-slc5-gcc43-opt", "i686&linux&slc5&gcc&gcc43&opt", true},
+		{"atlasoff", "x86_64-slc5-icc11-opt", "x86_64&linux&slc5&icc&icc11&opt", true},
+		{"atlasoff", "x86_64-mac106-gcc42-opt", "x86_64&darwin&mac106&gcc&gcc42&opt", true},
+		{"atlasoff", "i686-slc4-gcc34-opt", "i686-slc4-gcc34-opt", false},
+		{"atlasoff", "x86_64-slc5&gcc43", "x86_64&linux&slc5&gcc&gcc43", true},
+		{"atlasoff", "target-dbg", "dbg", true},
+		{"tdaq", "x86_64-slc6-gcc47-opt", "x86_64&linux&slc6&gcc&gcc47&opt", true},
+		{"tdaq", "i686-slc5-gcc43-dbg", "i686&linux&slc5&gcc&gcc43&dbg", true},
+		{"tdaq", "x86_64-slc6-clang32-opt", "x86_64&linux&slc6&clang&clang32&opt", true},
+		{"tdaq", "ppc-rtems-rce405-opt", "ppc-rtems-rce405-opt", false},
+		{"tdaq", "!icc11", "!icc11", true},
+		{"tdaq", "!slc5&gcc47", "!slc5&gcc&gcc47", true},
+	} {
+		tag, ok, err := g_profiles[table.profile].tags.Translate(table.tag)
+		if err != nil {
+			t.Fatalf("%s: %s: unexpected error: %v", table.profile, table.tag, err)
+		}
+		if tag != table.expected || ok != table.ok {
+			t.Fatalf("%s: %s: expected (%q, %v). got (%q, %v)", table.profile, table.tag, table.expected, table.ok, tag, ok)
+		}
+	}
+}
+
+func TestLoadTagTable(t *testing.T) {
+	tbl, err := load_tag_table("testdata/tags.map")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := TagTable{
+		"x86_64-slc5": "x86_64&linux&slc5",
+		"icc11":       "icc",
+		"ppc-rtems":   "",
+	}
+	if !reflect.DeepEqual(tbl, expected) {
+		t.Fatalf("expected %v. got %v", expected, tbl)
+	}
+}
+
+func TestTranslateTags(t *testing.T) {
+	req, err := parse_file("testdata/tagged.txt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	orig := g_profile.tags
+	defer func() { g_profile.tags = orig }()
+	g_profile.tags = new_tag_table()
+
+	r, err := NewRenderer(req)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = r.analyze()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var val hlib.Value
+	for _, stmt := range r.pkg.Configure.Stmts {
+		if x, ok := stmt.(*hlib.MacroStmt); ok && x.Value.Name == "flags" {
+			val = x.Value
+		}
+	}
+	tags := []string{}
+	for _, kv := range val.Set {
+		tags = append(tags, kv.Tag)
+	}
+	expected := []string{"default", "x86_64&slc5&gcc43", "linux"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("expected tags %v. got %v", expected, tags)
+	}
+
+	msgs := []string{}
+	for _, diag := range req.Diags {
+		if !diag.Warn {
+			t.Fatalf("unexpected error: %v", diag)
+		}
+		msgs = append(msgs, diag.Error())
+	}
+	expected = []string{
+		"testdata/tagged.txt:3: flags: tag alternative [i686-slc4&gcc34] dropped (obsolete platform)",
+	}
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Fatalf("expected diagnostics %q. got %q", expected, msgs)
+	}
+
+	// the requirements file keeps its CMT tags
+	x := req.Stmts[1].(*Macro)
+	if len(x.Set) != 4 || x.Set[2].Tag != "i686-slc4&gcc34" {
+		t.Fatalf("requirements statement was modified: %v", x.Set)
+	}
+}
+
+func TestTranslateTagsCopy(t *testing.T) {
+	req, err := parse_file("testdata/linkopts.txt")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	r, err := NewRenderer(req)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = r.analyze()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	_, tgt := find_tgt(&r.pkg, "Links")
+	if tgt == nil {
+		t.Fatalf("no Links target")
+	}
+	use := []string{}
+	for _, v := range tgt.Use {
+		for _, kv := range v.Set {
+			use = append(use, kv.Value...)
+		}
+	}
+	if expected := []string{"Foo", "${BAR_LIB}"}; !reflect.DeepEqual(use, expected) {
+		t.Fatalf("expected uses %v. got %v", expected, use)
+	}
+
+	// the requirements file keeps its values
+	values := []string{}
+	for _, stmt := range req.Stmts {
+		if v := stmt_value(stmt); v != nil {
+			values = append(values, v.Set[0].Value...)
+		}
+	}
+	if expected := []string{"-lFoo", "-l${BAR_LIB}"}; !reflect.DeepEqual(values, expected) {
+		t.Fatalf("requirements statements were modified: expected %v. got %v", expected, values)
+	}
+}
+
+// EOF
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hwaf/hwaf/hlib"
+)
+
+// TagExpr is a parsed CMT tag selector, as used by the tag alternatives
+// of macro, path and set statements. e.g.:
+//
+//	x86_64-slc5&gcc43
+//	i686&!icc11
+//
+// A TagExpr is the conjunction ('&') of its terms.
+type TagExpr struct {
+	Terms []TagTerm
+}
+
+// TagTerm is an operand of a tag expression: a '-'-separated list of
+// platform components (e.g. x86_64-slc5), possibly negated with '!'.
+type TagTerm struct {
+	Neg   bool
+	Parts []string
+}
+
+// ParseTagExpr parses a CMT tag selector
+func ParseTagExpr(s string) (TagExpr, error) {
+	var expr TagExpr
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return expr, fmt.Errorf("empty tag expression")
+	}
+	for _, str := range strings.Split(s, "&") {
+		str = strings.TrimSpace(str)
+		term := TagTerm{}
+		if strings.HasPrefix(str, "!") {
+			term.Neg = true
+			str = str[1:]
+		}
+		if str == "" {
+			return expr, fmt.Errorf("empty term in tag expression [%s]", s)
+		}
+		for _, part := range strings.Split(str, "-") {
+			if part == "" {
+				return expr, fmt.Errorf("empty component in tag expression [%s]", s)
+			}
+			term.Parts = append(term.Parts, part)
+		}
+		expr.Terms = append(expr.Terms, term)
+	}
+	return expr, nil
+}
+
+func (term TagTerm) String() string {
+	s := strings.Join(term.Parts, "-")
+	if term.Neg {
+		s = "!" + s
+	}
+	return s
+}
+
+func (expr TagExpr) String() string {
+	terms := make([]string, 0, len(expr.Terms))
+	for _, term := range expr.Terms {
+		terms = append(terms, term.String())
+	}
+	return strings.Join(terms, "&")
+}
+
+// IsDefault returns whether expr is the catch-all "default" tag
+func (expr TagExpr) IsDefault() bool {
+	return expr.String() == "default"
+}
+
+// Normalize returns an equivalent expression with sorted and
+// de-duplicated terms
+func (expr TagExpr) Normalize() TagExpr {
+	terms := make([]TagTerm, 0, len(expr.Terms))
+	seen := make(map[string]bool, len(expr.Terms))
+	for _, term := range expr.Terms {
+		s := term.String()
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		terms = append(terms, term)
+	}
+	sort.Sort(tag_terms(terms))
+	return TagExpr{Terms: terms}
+}
+
+// Compare orders tag expressions on their normalized form.
+// It returns 0 for equivalent expressions.
+func (expr TagExpr) Compare(o TagExpr) int {
+	return strings.Compare(expr.Normalize().String(), o.Normalize().String())
+}
+
+// Equal returns whether both expressions select the same tags
+func (expr TagExpr) Equal(o TagExpr) bool {
+	return expr.Compare(o) == 0
+}
+
+// Subsumes returns whether expr matches whenever o does, i.e. whether
+// every term of expr is implied by a term of o.
+// as for Match, terms are compared on their components: x86_64 subsumes
+// x86_64-slc5, and !icc-opt subsumes !icc.
+func (expr TagExpr) Subsumes(o TagExpr) bool {
+	for _, term := range expr.Terms {
+		implied := false
+		for _, oterm := range o.Terms {
+			if term.implied_by(oterm) {
+				implied = true
+				break
+			}
+		}
+		if !implied {
+			return false
+		}
+	}
+	return true
+}
+
+// implied_by returns whether term holds whenever o does.
+// a term holds when all of its components are active, so a term is
+// implied by any term with more components, and a negated term by any
+// negated term with fewer components.
+func (term TagTerm) implied_by(o TagTerm) bool {
+	if term.Neg != o.Neg {
+		return false
+	}
+	if term.Neg {
+		return str_is_subset(o.Parts, term.Parts)
+	}
+	return str_is_subset(term.Parts, o.Parts)
+}
+
+// str_is_subset returns whether every string of sub is in slice
+func str_is_subset(sub, slice []string) bool {
+	for _, str := range sub {
+		if !str_is_in_slice(slice, str) {
+			return false
+		}
+	}
+	return true
+}
+
+// Components returns the platform components of the (non-negated) terms
+func (expr TagExpr) Components() []string {
+	parts := []string{}
+	for _, term := range expr.Terms {
+		if term.Neg {
+			continue
+		}
+		parts = append(parts, term.Parts...)
+	}
+	return parts
+}
+
+// Match returns whether expr selects the given set of active tags.
+// as for Subsumes, terms are compared on their components: a term matches
+// if all of its components are active, and an active tag activates all of
+// its components (x86_64-slc6 activates x86_64 and slc6).
+func (expr TagExpr) Match(tags map[string]bool) bool {
+	active := make(map[string]bool, len(tags))
+	for tag, ok := range tags {
+		if !ok {
+			continue
+		}
+		for _, part := range strings.Split(tag, "-") {
+			active[part] = true
+		}
+	}
+	for _, term := range expr.Terms {
+		ok := true
+		for _, part := range term.Parts {
+			if !active[part] {
+				ok = false
+				break
+			}
+		}
+		if ok == term.Neg {
+			return false
+		}
+	}
+	return true
+}
+
+type tag_terms []TagTerm
+
+func (p tag_terms) Len() int           { return len(p) }
+func (p tag_terms) Less(i, j int) bool { return p[i].String() < p[j].String() }
+func (p tag_terms) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// stmt_value returns the tagged value held by a statement, if any
+func stmt_value(stmt Stmt) *hlib.Value {
+	switch x := stmt.(type) {
+	case *Alias:
+		return (*hlib.Value)(x)
+	case *Macro:
+		return (*hlib.Value)(x)
+	case *MacroAppend:
+		return (*hlib.Value)(x)
+	case *MacroPrepend:
+		return (*hlib.Value)(x)
+	case *MacroRemove:
+		return (*hlib.Value)(x)
+	case *MacroRemoveAll:
+		return (*hlib.Value)(x)
+	case *MacroRemoveRegexp:
+		return (*hlib.Value)(x)
+	case *MacroRemoveAllRegexp:
+		return (*hlib.Value)(x)
+	case *Path:
+		return (*hlib.Value)(x)
+	case *PathAppend:
+		return (*hlib.Value)(x)
+	case *PathPrepend:
+		return (*hlib.Value)(x)
+	case *PathRemove:
+		return (*hlib.Value)(x)
+	case *PathRemoveRegexp:
+		return (*hlib.Value)(x)
+	case *SetEnv:
+		return (*hlib.Value)(x)
+	case *SetAppend:
+		return (*hlib.Value)(x)
+	case *SetPrepend:
+		return (*hlib.Value)(x)
+	case *SetRemove:
+		return (*hlib.Value)(x)
+	case *SetRemoveRegexp:
+		return (*hlib.Value)(x)
+	}
+	return nil
+}
+
+// check_value_tags validates the tag alternatives of a value and reports
+// the alternatives which can never be selected, as an earlier one always
+// matches first.
+func check_value_tags(v *hlib.Value) []error {
+	errs := []error{}
+	seen := []TagExpr{}
+	for _, kv := range v.Set {
+		expr, err := ParseTagExpr(kv.Tag)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", v.Name, err))
+			continue
+		}
+		if expr.IsDefault() {
+			continue
+		}
+		for _, prev := range seen {
+			if prev.Subsumes(expr) {
+				errs = append(errs, fmt.Errorf(
+					"%s: tag alternative [%s] is shadowed by [%s]",
+					v.Name, expr, prev,
+				))
+				break
+			}
+		}
+		seen = append(seen, expr)
+	}
+	return errs
+}
+
+// EOF
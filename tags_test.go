@@ -0,0 +1,198 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTagExpr(t *testing.T) {
+	for _, table := range []struct {
+		str  string
+		norm string
+		comp []string
+	}{
+		{
+			str:  "default",
+			norm: "default",
+			comp: []string{"default"},
+		},
+		{
+			str:  "x86_64-slc5&gcc43",
+			norm: "gcc43&x86_64-slc5",
+			comp: []string{"x86_64", "slc5", "gcc43"},
+		},
+		{
+			str:  " i686 & !icc11 & i686",
+			norm: "!icc11&i686",
+			comp: []string{"i686", "i686"},
+		},
+		{
+			str:  "ppc-rtems-rce405",
+			norm: "ppc-rtems-rce405",
+			comp: []string{"ppc", "rtems", "rce405"},
+		},
+	} {
+		expr, err := ParseTagExpr(table.str)
+		if err != nil {
+			t.Fatalf("%q: %v", table.str, err)
+		}
+		if norm := expr.Normalize().String(); norm != table.norm {
+			t.Fatalf("%q: expected normalized form %q. got %q", table.str, table.norm, norm)
+		}
+		comp := expr.Components()
+		if len(comp) != len(table.comp) {
+			t.Fatalf("%q: expected components %q. got %q", table.str, table.comp, comp)
+		}
+		for i := range comp {
+			if comp[i] != table.comp[i] {
+				t.Fatalf("%q: expected components %q. got %q", table.str, table.comp, comp)
+			}
+		}
+	}
+
+	for _, str := range []string{"", "a&&b", "a--b", "!", "x86_64-"} {
+		_, err := ParseTagExpr(str)
+		if err == nil {
+			t.Fatalf("%q: expected an error", str)
+		}
+	}
+}
+
+func TestTagExprMatch(t *testing.T) {
+	tags := map[string]bool{
+		"x86_64-slc6-gcc47-opt": true,
+		"x86_64":                true,
+		"slc6":                  true,
+		"gcc47":                 true,
+		"opt":                   true,
+		"target-slc6":           true,
+	}
+	for _, table := range []struct {
+		str      string
+		expected bool
+	}{
+		{"x86_64", true},
+		{"x86_64-slc6", true},
+		{"x86_64-slc6&gcc47", true},
+		{"gcc47&x86_64-slc6", true},
+		{"x86_64-slc5&gcc43", false},
+		{"x86_64&!icc11", true},
+		{"x86_64&!gcc47", false},
+		{"target-slc6", true},
+		{"i686-slc6", false},
+	} {
+		expr, err := ParseTagExpr(table.str)
+		if err != nil {
+			t.Fatalf("%q: %v", table.str, err)
+		}
+		if o := expr.Match(tags); o != table.expected {
+			t.Fatalf("%q: expected %v. got %v", table.str, table.expected, o)
+		}
+	}
+
+	a, _ := ParseTagExpr("x86_64-slc5&gcc43")
+	b, _ := ParseTagExpr("gcc43 & x86_64-slc5")
+	if !a.Equal(b) {
+		t.Fatalf("expected %v == %v", a, b)
+	}
+}
+
+func TestCheckValueTags(t *testing.T) {
+	v := hlib_value_from_slice("foo", []string{
+		"", "x86_64", "a", "x86_64&gcc43", "b", "gcc43&x86_64-slc5", "c",
+		"gcc43&x86_64-slc5", "d", "i686&&gcc43", "e",
+	})
+	errs := check_value_tags(&v)
+	expected := []string{
+		"foo: tag alternative [x86_64&gcc43] is shadowed by [x86_64]",
+		"foo: tag alternative [gcc43&x86_64-slc5] is shadowed by [x86_64]",
+		"foo: tag alternative [gcc43&x86_64-slc5] is shadowed by [x86_64]",
+		"foo: empty term in tag expression [i686&&gcc43]",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors. got %d: %v", len(expected), len(errs), errs)
+	}
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Fatalf("error #%d: expected %q. got %q", i, expected[i], err.Error())
+		}
+	}
+}
+
+func TestTagExprSubsumes(t *testing.T) {
+	for _, table := range []struct {
+		a, b     string
+		expected bool
+	}{
+		{"x86_64", "x86_64", true},
+		{"x86_64", "x86_64-slc5", true},
+		{"x86_64-slc5", "x86_64", false},
+		{"slc5", "x86_64-slc5-gcc43", true},
+		{"x86_64-gcc43", "x86_64-slc5-gcc43", true},
+		{"x86_64", "gcc43&x86_64-slc5", true},
+		{"x86_64&gcc43", "x86_64-slc5-gcc43", true},
+		{"x86_64&gcc43", "x86_64-slc5", false},
+		{"i686", "x86_64-slc5", false},
+		{"!icc", "!icc", true},
+		{"!icc-opt", "!icc", true},
+		{"!icc", "!icc-opt", false},
+		{"!icc", "icc", false},
+		{"x86_64", "!i686", false},
+	} {
+		a, err := ParseTagExpr(table.a)
+		if err != nil {
+			t.Fatalf("%q: %v", table.a, err)
+		}
+		b, err := ParseTagExpr(table.b)
+		if err != nil {
+			t.Fatalf("%q: %v", table.b, err)
+		}
+		if o := a.Subsumes(b); o != table.expected {
+			t.Fatalf("%q subsumes %q: expected %v. got %v", table.a, table.b, table.expected, o)
+		}
+	}
+}
+
+func TestTagExprMatchSubsumes(t *testing.T) {
+	// Match and Subsumes share the component model: whenever b matches,
+	// an expression subsuming b matches too
+	actives := []map[string]bool{
+		{"x86_64-slc5": true},
+		{"x86_64-slc5-gcc43-opt": true},
+		{"x86_64": true, "slc5": true},
+		{"icc-opt": true},
+		{"icc": true},
+		{"i686-slc6": true, "gcc47": true},
+	}
+	exprs := []string{
+		"x86_64", "x86_64-slc5", "slc5", "x86_64&gcc43", "x86_64-slc5-gcc43",
+		"gcc43&x86_64-slc5", "i686", "!icc", "!icc-opt", "icc", "!x86_64-slc5",
+	}
+	for _, sa := range exprs {
+		a, err := ParseTagExpr(sa)
+		if err != nil {
+			t.Fatalf("%q: %v", sa, err)
+		}
+		for _, sb := range exprs {
+			b, err := ParseTagExpr(sb)
+			if err != nil {
+				t.Fatalf("%q: %v", sb, err)
+			}
+			if !a.Subsumes(b) {
+				continue
+			}
+			for _, tags := range actives {
+				if b.Match(tags) && !a.Match(tags) {
+					t.Fatalf("%q subsumes %q, but only %q matches %v", sa, sb, sb, tags)
+				}
+			}
+		}
+	}
+
+	// a joined active tag activates its components
+	expr, _ := ParseTagExpr("x86_64")
+	if !expr.Match(map[string]bool{"x86_64-slc5": true}) {
+		t.Fatalf("expected x86_64 to match the active tag x86_64-slc5")
+	}
+}
+
+// EOF
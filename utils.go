@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -67,6 +69,21 @@ func str_is_in_slice(slice []string, str string) bool {
 	return false
 }
 
+// str_unique returns the sorted list of the distinct strings of slice
+func str_unique(slice []string) []string {
+	set := make(map[string]struct{}, len(slice))
+	out := make([]string, 0, len(slice))
+	for _, s := range slice {
+		if _, dup := set[s]; dup {
+			continue
+		}
+		set[s] = struct{}{}
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
 // re_is_in_slice_suffix returns true if an element in the given slice of strings is a prefix of value.
 func re_is_in_slice_suffix(slice []string, macro, pattern string) bool {
 	for _, s := range slice {
@@ -167,4 +184,36 @@ func w_py_strlist(str []string) string {
 	return strings.Join(o, ", ")
 }
 
+// read_table reads a file of "key value" lines.
+// '#' starts a comment, empty lines are ignored.
+// what describes the expected fields, for error messages.
+func read_table(fname, what string) (map[string]string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tbl := make(map[string]string)
+	scan := bufio.NewScanner(f)
+	lineno := 0
+	for scan.Scan() {
+		lineno++
+		line := scan.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected %s (got %q)", fname, lineno, what, line)
+		}
+		tbl[fields[0]] = fields[1]
+	}
+	err = scan.Err()
+	return tbl, err
+}
+
 // EOF
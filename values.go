@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// ValueExpr is a parsed CMT value: a sequence of literal strings and of
+// macro references. e.g.:
+//
+//	-I$(FOOROOT)/include -DFOO=bar(1)
+type ValueExpr []ValuePart
+
+// ValuePart is either a literal string or a macro reference
+type ValuePart struct {
+	Lit string
+	Ref *MacroRef // nil for a literal
+}
+
+// MacroRef is a $(name) or ${name} reference.
+// the name may itself hold references, e.g. $($(package)_root)
+type MacroRef struct {
+	Brace bool // true for ${name}, false for $(name)
+	Name  ValueExpr
+}
+
+// g_ref_name_re matches the literal parts of a reference name.
+// anything else (e.g. $(shell echo foo)) is not a macro reference.
+var g_ref_name_re = regexp.MustCompile(`^[\w.-]*$`)
+
+// ParseValue parses the macro references of a CMT value.
+// malformed or unterminated references are kept as literals.
+func ParseValue(s string) ValueExpr {
+	v, _ := parse_value(s, 0, 0)
+	return v
+}
+
+// parse_value parses s[beg:] up to the closing delimiter end (or to the
+// end of the string if end is 0) and returns the parsed value and the
+// index right after the closing delimiter, or -1 if it was not found.
+func parse_value(s string, beg int, end byte) (ValueExpr, int) {
+	v := ValueExpr{}
+	lit := []byte{}
+	flush := func() {
+		if len(lit) > 0 {
+			v = append(v, ValuePart{Lit: string(lit)})
+			lit = []byte{}
+		}
+	}
+	for i := beg; i < len(s); {
+		c := s[i]
+		if end != 0 && c == end {
+			flush()
+			return v, i + 1
+		}
+		if c == '$' && i+1 < len(s) && (s[i+1] == '(' || s[i+1] == '{') {
+			closer := byte(')')
+			if s[i+1] == '{' {
+				closer = '}'
+			}
+			name, next := parse_value(s, i+2, closer)
+			if next >= 0 && len(name) > 0 && name.is_name() {
+				flush()
+				v = append(v, ValuePart{Ref: &MacroRef{Brace: closer == '}', Name: name}})
+				i = next
+				continue
+			}
+			if next >= 0 {
+				// not a macro reference: keep it verbatim
+				lit = append(lit, s[i:next]...)
+				i = next
+				continue
+			}
+		}
+		lit = append(lit, c)
+		i++
+	}
+	flush()
+	if end != 0 {
+		return v, -1
+	}
+	return v, len(s)
+}
+
+// is_name returns whether v is a valid reference name
+func (v ValueExpr) is_name() bool {
+	for _, part := range v {
+		if part.Ref == nil && !g_ref_name_re.MatchString(part.Lit) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the value in CMT syntax
+func (v ValueExpr) String() string {
+	return v.format(func(ref *MacroRef, name string) string {
+		if ref.Brace {
+			return "${" + name + "}"
+		}
+		return "$(" + name + ")"
+	})
+}
+
+// Hwaf returns the value with every reference in hwaf syntax: ${name}
+func (v ValueExpr) Hwaf() string {
+	return v.format(func(ref *MacroRef, name string) string {
+		return "${" + name + "}"
+	})
+}
+
+func (v ValueExpr) format(fct func(ref *MacroRef, name string) string) string {
+	o := new(bytes.Buffer)
+	for _, part := range v {
+		if part.Ref == nil {
+			o.WriteString(part.Lit)
+			continue
+		}
+		o.WriteString(fct(part.Ref, part.Ref.Name.format(fct)))
+	}
+	return o.String()
+}
+
+// Refs returns the names of the macros referenced by v, in order.
+// references with a computed name are reported in CMT syntax.
+func (v ValueExpr) Refs() []string {
+	refs := []string{}
+	for _, part := range v {
+		if part.Ref == nil {
+			continue
+		}
+		refs = append(refs, part.Ref.Name.String())
+		refs = append(refs, part.Ref.Name.Refs()...)
+	}
+	return refs
+}
+
+// Expand substitutes the macro references of v with the values returned
+// by lookup. references lookup does not know about are kept as-is.
+func (v ValueExpr) Expand(lookup func(name string) (string, bool)) string {
+	o := new(bytes.Buffer)
+	for _, part := range v {
+		if part.Ref == nil {
+			o.WriteString(part.Lit)
+			continue
+		}
+		name := part.Ref.Name.Expand(lookup)
+		if val, ok := lookup(name); ok {
+			o.WriteString(val)
+			continue
+		}
+		ref := MacroRef{Brace: part.Ref.Brace, Name: ValueExpr{{Lit: name}}}
+		o.WriteString(ValueExpr{{Ref: &ref}}.String())
+	}
+	return o.String()
+}
+
+// EOF
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseValue(t *testing.T) {
+	for _, table := range []struct {
+		value string
+		hwaf  string
+		refs  []string
+	}{
+		{
+			value: "-DFOO=bar(1)",
+			hwaf:  "-DFOO=bar(1)",
+			refs:  []string{},
+		},
+		{
+			value: "$(FOOROOT)/include",
+			hwaf:  "${FOOROOT}/include",
+			refs:  []string{"FOOROOT"},
+		},
+		{
+			value: "${FOOROOT}/include -I$(bar_root)",
+			hwaf:  "${FOOROOT}/include -I${bar_root}",
+			refs:  []string{"FOOROOT", "bar_root"},
+		},
+		{
+			value: "$($(package)_root)/lib",
+			hwaf:  "${${package}_root}/lib",
+			refs:  []string{"$(package)_root", "package"},
+		},
+		{
+			value: "$(shell echo foo) (a) $(b",
+			hwaf:  "$(shell echo foo) (a) $(b",
+			refs:  []string{},
+		},
+		{
+			value: "echo $(a) | sed -e 's/)/}/'",
+			hwaf:  "echo ${a} | sed -e 's/)/}/'",
+			refs:  []string{"a"},
+		},
+		{
+			value: "$()$",
+			hwaf:  "$()$",
+			refs:  []string{},
+		},
+	} {
+		v := ParseValue(table.value)
+		if s := v.String(); s != table.value {
+			t.Fatalf("%q: round-trip failed: %q", table.value, s)
+		}
+		if s := v.Hwaf(); s != table.hwaf {
+			t.Fatalf("%q: expected %q. got %q", table.value, table.hwaf, s)
+		}
+		if refs := v.Refs(); !reflect.DeepEqual(refs, table.refs) {
+			t.Fatalf("%q: expected refs %q. got %q", table.value, table.refs, refs)
+		}
+	}
+}
+
+func TestExpandValue(t *testing.T) {
+	macros := map[string]string{
+		"package":  "Foo",
+		"Foo_root": "/opt/Foo",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := macros[name]
+		return v, ok
+	}
+	v := ParseValue("$($(package)_root)/lib:${unknown}:$(bar(1))")
+	expected := "/opt/Foo/lib:${unknown}:$(bar(1))"
+	if s := v.Expand(lookup); s != expected {
+		t.Fatalf("expected %q. got %q", expected, s)
+	}
+}
+
+// EOF
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// VarTable rewrites CMT built-in variables into hwaf ones.
+// keys are CMT variable names, values are their hwaf replacements.
+// both may hold the placeholders:
+//
+//	<package>  the name of the package, e.g. AthenaKernel
+//	<PACKAGE>  the upper-cased name of the package, e.g. ATHENAKERNEL
+//	<version>  the version of the package
+type VarTable map[string]string
+
+// g_cmt_vars holds the rewrites common to all profiles
+var g_cmt_vars = VarTable{
+	// platform
+	"CMTCONFIG": "${CMTCFG}",
+	"tag":       "${CMTCFG}",
+
+	// install area
+	"CMTINSTALLAREA": "${INSTALL_AREA}",
+
+	// package
+	"package":        "<package>",
+	"version":        "<version>",
+	"PACKAGE_ROOT":   "${PKG_SRCDIR}",
+	"<package>_root": "${PKG_SRCDIR}",
+	"<PACKAGE>ROOT":  "${PKG_SRCDIR}",
+}
+
+// g_cmt_builtin_re matches the CMT built-in variables.
+// those without a rewrite are reported.
+// bin, the build directory of the package (../$(tag)/), has no hwaf
+// equivalent.
+var g_cmt_builtin_re = regexp.MustCompile(`^(CMT[A-Z]+|cmt_\w+|bin|tag|package|version|src|doc|mgr)$`)
+
+// g_cmt_pkg_var_re matches the variables CMT defines for each package, e.g.
+// Foo_root or FOOROOT.
+// those of packages the requirements file does not use are reported.
+var g_cmt_pkg_var_re = regexp.MustCompile(`^(\w+?)(_root|_cmtpath|_offset|_project_release|_native_version|ROOT)$`)
+
+// new_var_table returns a table holding the common rewrites, overridden
+// by the given ones
+func new_var_table(tbls ...VarTable) VarTable {
+	out := make(VarTable, len(g_cmt_vars))
+	for _, tbl := range append([]VarTable{g_cmt_vars}, tbls...) {
+		for k, v := range tbl {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// load_var_table reads a variable rewrite file.
+// each line holds a CMT variable and its hwaf replacement:
+//
+//	# CMT variable   hwaf variable
+//	CMTCONFIG        ${HWAF_VARIANT}
+//	<package>_root   ${<PACKAGE>_SRCDIR}
+func load_var_table(fname string) (VarTable, error) {
+	tbl, err := read_table(fname, "a CMT variable and its hwaf replacement")
+	return VarTable(tbl), err
+}
+
+// Resolve returns the rewrites of a given package, with the placeholders
+// replaced by its name and version
+func (tbl VarTable) Resolve(pkg, version string) map[string]string {
+	repl := strings.NewReplacer(
+		"<package>", pkg,
+		"<PACKAGE>", strings.ToUpper(pkg),
+		"<version>", version,
+	)
+	out := make(map[string]string, len(tbl))
+	for k, v := range tbl {
+		out[repl.Replace(k)] = repl.Replace(v)
+	}
+	return out
+}
+
+// rewrite_vars returns the statements with the CMT built-in variables of
+// their values (and of the arguments of the remaining apply_pattern)
+// rewritten into hwaf ones.
+// statements are copied, not modified.
+// built-in variables with no rewrite are reported.
+func (r *Renderer) rewrite_vars(stmts []Stmt) []Stmt {
+	tbl := g_profile.vars
+	if tbl == nil {
+		tbl = new_var_table()
+	}
+	vars := tbl.Resolve(filepath.Base(r.pkg.Package.Name), req_version(r.req))
+
+	// the variables of the used packages are defined by their own hscript
+	used := make(map[string]bool)
+	for _, stmt := range r.req.Stmts {
+		if x, ok := stmt.(*UsePkg); ok {
+			used[strings.ToUpper(filepath.Base(x.Package))] = true
+		}
+	}
+	is_builtin := func(name string) bool {
+		if g_cmt_builtin_re.MatchString(name) {
+			return true
+		}
+		m := g_cmt_pkg_var_re.FindStringSubmatch(name)
+		return m != nil && !used[strings.ToUpper(strings.TrimSuffix(m[1], "_"))]
+	}
+
+	out := make([]Stmt, 0, len(stmts))
+	for _, stmt := range stmts {
+		unknown := []string{}
+		rewrite := func(s string) string {
+			return ParseValue(s).Expand(func(name string) (string, bool) {
+				v, ok := vars[name]
+				if !ok && is_builtin(name) && !str_is_in_slice(unknown, name) {
+					unknown = append(unknown, name)
+				}
+				return v, ok
+			})
+		}
+
+		var x Stmt
+		what := ""
+		if v := stmt_value(stmt); v != nil {
+			what = v.Name
+			set := make([][]string, len(v.Set))
+			changed := false
+			for i, kv := range v.Set {
+				set[i] = make([]string, len(kv.Value))
+				for j, s := range kv.Value {
+					set[i][j] = rewrite(s)
+					changed = changed || set[i][j] != s
+				}
+			}
+			if changed {
+				x = copy_stmt(r.req, stmt)
+				xv := stmt_value(x)
+				xv.Set = append(xv.Set[:0:0], v.Set...)
+				for i := range xv.Set {
+					xv.Set[i].Value = set[i]
+				}
+			}
+		} else if v, ok := stmt.(*ApplyPattern); ok {
+			what = "apply_pattern " + v.Name
+			args := make([]string, len(v.Args))
+			changed := false
+			for i, arg := range v.Args {
+				args[i] = rewrite(arg)
+				changed = changed || args[i] != arg
+			}
+			if changed {
+				x = copy_stmt(r.req, stmt)
+				x.(*ApplyPattern).Args = args
+			}
+		}
+
+		for _, name := range unknown {
+			r.req.warn(stmt, fmt.Errorf("%s: no hwaf equivalent for CMT built-in [%s]", what, name))
+		}
+		if x == nil {
+			x = stmt
+		}
+		out = append(out, x)
+	}
+	return out
+}
+
+// EOF
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hwaf/hwaf/hlib"
+)
+
+// render_vars analyzes a requirements file and returns the values of its
+// macros, paths and sets, with the diagnostics of the file
+func render_vars(t *testing.T, fname string, tbl VarTable) (map[string][]string, []string) {
+	req, err := parse_file(fname)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	orig := g_profile.vars
+	defer func() { g_profile.vars = orig }()
+	g_profile.vars = tbl
+
+	r, err := NewRenderer(req)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = r.analyze()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	vals := make(map[string][]string)
+	for _, stmt := range append(r.pkg.Configure.Stmts, r.pkg.Build.Stmts...) {
+		var v hlib.Value
+		switch x := stmt.(type) {
+		case *hlib.MacroStmt:
+			v = x.Value
+		case *hlib.PathPrependStmt:
+			v = x.Value
+		case *hlib.SetStmt:
+			v = x.Value
+		default:
+			continue
+		}
+		for _, kv := range v.Set {
+			vals[v.Name] = append(vals[v.Name], strings.Join(kv.Value, " "))
+		}
+	}
+
+	msgs := []string{}
+	for _, diag := range req.Diags {
+		msgs = append(msgs, diag.Error())
+	}
+	return vals, msgs
+}
+
+func TestRewriteVars(t *testing.T) {
+	const fname = "testdata/vars/Tools/Foo/cmt/requirements"
+	vals, msgs := render_vars(t, fname, new_var_table())
+
+	expected := map[string][]string{
+		"Foo_cppflags": {"-I${PKG_SRCDIR}/include -DCFG=${CMTCFG}"},
+		"PATH":         {"${PKG_SRCDIR}/${CMTCFG}/bin"},
+		"FOO_HOME":     {"${CMTROOT}/foo", "${Bar_root}/foo"},
+		"Foo_linkopts": {"-L${bin} -lFoo -lBaz"},
+		"Foo_data":     {"${Qux_root}/data ${Bar_root}/data"},
+	}
+	for name, exp := range expected {
+		if !reflect.DeepEqual(vals[name], exp) {
+			t.Fatalf("%s: expected %q. got %q", name, exp, vals[name])
+		}
+	}
+
+	exp := []string{
+		fname + ":5: FOO_HOME: no hwaf equivalent for CMT built-in [CMTROOT]",
+		fname + ":7: Foo_linkopts: no hwaf equivalent for CMT built-in [bin]",
+		// Bar is used: its variables are not reported
+		fname + ":8: Foo_data: no hwaf equivalent for CMT built-in [Qux_root]",
+	}
+	if !reflect.DeepEqual(msgs, exp) {
+		t.Fatalf("expected diagnostics %q. got %q", exp, msgs)
+	}
+}
+
+func TestLoadVarTable(t *testing.T) {
+	tbl, err := load_var_table("testdata/vars.map")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := VarTable{
+		"CMTCONFIG":      "${HWAF_VARIANT}",
+		"<package>_root": "${<PACKAGE>_SRCDIR}",
+	}
+	if !reflect.DeepEqual(tbl, expected) {
+		t.Fatalf("expected %v. got %v", expected, tbl)
+	}
+
+	vals, _ := render_vars(t, "testdata/vars/Tools/Foo/cmt/requirements", new_var_table(tbl))
+	exp := []string{"-I${FOO_SRCDIR}/include -DCFG=${HWAF_VARIANT}"}
+	if !reflect.DeepEqual(vals["Foo_cppflags"], exp) {
+		t.Fatalf("expected %q. got %q", exp, vals["Foo_cppflags"])
+	}
+}
+
+func TestRewriteVarsPatternArgs(t *testing.T) {
+	req := &ReqFile{
+		Filename: "testdata/vars/Tools/Foo/cmt/requirements",
+		Infos:    make(map[Stmt]*StmtInfo),
+	}
+	x := &ApplyPattern{
+		Name: "install_runtime",
+		Args: []string{"files=$(Foo_root)/share/*.dat", "version=$(version)"},
+	}
+	r, err := NewRenderer(req)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	r.pkg.Package.Name = "Tools/Foo"
+	stmts := r.rewrite_vars([]Stmt{x})
+
+	expected := []string{"files=${PKG_SRCDIR}/share/*.dat", "version=1.2.3"}
+	if args := stmts[0].(*ApplyPattern).Args; !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %q. got %q", expected, args)
+	}
+	if x.Args[0] != "files=$(Foo_root)/share/*.dat" {
+		t.Fatalf("apply_pattern statement was modified: %v", x.Args)
+	}
+}
+
+// EOF